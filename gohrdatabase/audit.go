@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The actions recorded in the audit trail, one for each PersonStore method that changes a person.
+// Purges are recorded too, so the history shows when a record was removed for good.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditPatch   = "patch"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// anonymousActor is the actor of the changes made when authentication is disabled.
+const anonymousActor = "anonymous"
+
+// auditWriteTimeout is how long recording an audit event may take once the
+// change it records has been written, even if the request is cancelled.
+const auditWriteTimeout = 5 * time.Second
+
+// An AuditEvent records a change to a person: who made it, when, in which
+// request, and the value of each changed field before and after it.
+type AuditEvent struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	PersonID primitive.ObjectID `bson:"person_id" json:"person_id"`
+	Action   string             `bson:"action" json:"action"`
+	// Actor is the subject of the caller, or "anonymous" without authentication.
+	Actor     string    `bson:"actor" json:"actor"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	// Version is the version of the person after the change, 0 once purged.
+	Version int64         `bson:"version,omitempty" json:"version,omitempty"`
+	Changes []FieldChange `bson:"changes" json:"changes"`
+}
+
+// A FieldChange is the value of a field before and after a change, null if the
+// field was not set. Field is its dotted JSON path, e.g. "location.city".
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+// newAuditEvent returns the event of a change from before to after, either of
+// which is nil when the person is created or deleted, made by the caller of
+// the request the context belongs to.
+func newAuditEvent(ctx context.Context, action string, before, after *Person) (AuditEvent, error) {
+	event := AuditEvent{
+		ID:        primitive.NewObjectID(),
+		Action:    action,
+		Actor:     anonymousActor,
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+		RequestID: RequestID(ctx),
+	}
+
+	if identity := IdentityFromContext(ctx); identity != nil {
+		event.Actor = identity.Subject
+	}
+
+	if after != nil {
+		event.PersonID = after.ID
+		event.Version = after.Version
+	} else if before != nil {
+		event.PersonID = before.ID
+	}
+
+	var err error
+	event.Changes, err = diffPeople(before, after)
+	return event, err
+}
+
+// newPurgeEvent returns the event of the purge of the person. It names the
+// fields the person had without their values, as a purge removes the data of
+// the person for good.
+func newPurgeEvent(ctx context.Context, person *Person) (AuditEvent, error) {
+	event, err := newAuditEvent(ctx, AuditPurge, person, nil)
+	event.Changes = redactValues(event.Changes)
+	return event, err
+}
+
+// redactValues returns a copy of the changes without their values, keeping
+// only the fields they changed.
+func redactValues(changes []FieldChange) []FieldChange {
+	redacted := make([]FieldChange, len(changes))
+	for i, change := range changes {
+		redacted[i] = FieldChange{Field: change.Field}
+	}
+
+	return redacted
+}
+
+// diffPeople returns the changes of the fields that differ between the people,
+// sorted by field. A nil person has no fields.
+func diffPeople(before, after *Person) ([]FieldChange, error) {
+	beforeFields, err := personFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := personFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FieldChange{}
+	for field, value := range beforeFields {
+		if afterValue, ok := afterFields[field]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changes = append(changes, FieldChange{Field: field, Before: value, After: afterFields[field]})
+		}
+	}
+
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, After: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
+
+// personFields returns the values of the leaf fields of the person's JSON
+// representation, other than its id and version, keyed by their dotted paths.
+func personFields(person *Person) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if person == nil {
+		return fields, nil
+	}
+
+	doc, err := toJSONDocument(*person)
+	if err != nil {
+		return nil, err
+	}
+
+	flattenDocument("", doc, fields)
+	delete(fields, "id")
+	delete(fields, "version")
+	return fields, nil
+}
+
+// flattenDocument adds the leaf values of a JSON document to fields, keyed by their dotted paths.
+func flattenDocument(prefix string, value interface{}, fields map[string]interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		fields[prefix] = value
+		return
+	}
+
+	for key, child := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		flattenDocument(path, child, fields)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestDiffPeople tests that the changes between two people list each changed leaf field once.
+func TestDiffPeople(t *testing.T) {
+	john := &Person{ID: primitive.NewObjectID(), Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}
+
+	tests := []struct {
+		Name     string
+		Before   *Person
+		After    *Person
+		Expected []FieldChange
+	}{
+		{
+			Name:  "create",
+			After: john,
+			Expected: []FieldChange{
+				{Field: "firstname", After: "John"},
+				{Field: "lastname", After: "Smith"},
+				{Field: "location.city", After: "London"},
+				{Field: "location.country", After: "GB"},
+			},
+		},
+		{
+			Name:   "change",
+			Before: john,
+			After:  &Person{ID: john.ID, Firstname: "Johnny", Lastname: "Smith", Location: &Location{City: "Paris", Country: "GB"}},
+			Expected: []FieldChange{
+				{Field: "firstname", Before: "John", After: "Johnny"},
+				{Field: "location.city", Before: "London", After: "Paris"},
+			},
+		},
+		{
+			Name:   "remove",
+			Before: john,
+			After:  &Person{ID: john.ID, Firstname: "John", Lastname: "Smith"},
+			Expected: []FieldChange{
+				{Field: "location.city", Before: "London"},
+				{Field: "location.country", Before: "GB"},
+			},
+		},
+		{
+			Name:     "unchanged",
+			Before:   john,
+			After:    john.Clone(),
+			Expected: []FieldChange{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			changes, err := diffPeople(tc.Before, tc.After)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, changes)
+		})
+	}
+}
+
+// TestAuditTrail tests that every change made through the API is recorded with
+// its actor and request ID, that GET /person/{id}/history lists the changes
+// even once the person is deleted, and that hidden fields are left out of them.
+func TestAuditTrail(t *testing.T) {
+	store := NewMemoryStore()
+	router := NewRouter(store, DefaultConfig(), newTestAuthenticator(t))
+
+	request := func(key, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		req.Header.Set(RequestIDHeader, "req-"+method)
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	history := func(key, id string) []AuditEvent {
+		rec := request(key, http.MethodGet, "/person/"+id+"/history", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var events []AuditEvent
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&events))
+		return events
+	}
+
+	var created Person
+	rec := request(testAPIKey, http.MethodPost, "/person", `{"firstname":"John","lastname":"Smith","location":{"city":"London","country":"GB"}}`)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	id := created.ID.Hex()
+
+	assert.Equal(t, http.StatusOK, request(testAPIKey, http.MethodPatch, "/person/"+id, `{"firstname":"Johnny","location":{"city":"Paris"}}`).Code)
+	assert.Equal(t, http.StatusOK, request(testAPIKey, http.MethodPut, "/person/"+id, `{"firstname":"Johnny","lastname":"Smythe"}`).Code)
+	assert.Equal(t, http.StatusOK, request(testAdminAPIKey, http.MethodDelete, "/person/"+id, "").Code)
+
+	events := history(testAdminAPIKey, id)
+	if assert.Len(t, events, 4) {
+		for i, expected := range []struct {
+			Action, Actor, RequestID string
+		}{
+			{AuditCreate, "payroll-sync", "req-POST"},
+			{AuditPatch, "payroll-sync", "req-PATCH"},
+			{AuditUpdate, "payroll-sync", "req-PUT"},
+			{AuditDelete, "hr-team", "req-DELETE"},
+		} {
+			assert.Equal(t, created.ID, events[i].PersonID)
+			assert.Equal(t, expected.Action, events[i].Action)
+			assert.Equal(t, expected.Actor, events[i].Actor)
+			assert.Equal(t, expected.RequestID, events[i].RequestID)
+			assert.False(t, events[i].Timestamp.IsZero())
+		}
+
+		assert.Equal(t, []FieldChange{
+			{Field: "firstname", Before: "John", After: "Johnny"},
+			{Field: "location.city", Before: "London", After: "Paris"},
+		}, events[1].Changes)
+		assert.Equal(t, []FieldChange{
+			{Field: "lastname", Before: "Smith", After: "Smythe"},
+			{Field: "location.city", Before: "Paris"},
+			{Field: "location.country", Before: "GB"},
+		}, events[2].Changes)
+	}
+
+	events = history(testViewerAPIKey, id)
+	if assert.Len(t, events, 4) {
+		assert.Equal(t, []FieldChange{{Field: "firstname", Before: "John", After: "Johnny"}}, events[1].Changes)
+		assert.Equal(t, []FieldChange{{Field: "lastname", Before: "Smith", After: "Smythe"}}, events[2].Changes)
+	}
+
+	assert.Equal(t, http.StatusNotFound, request(testAdminAPIKey, http.MethodGet, "/person/"+primitive.NewObjectID().Hex()+"/history", "").Code)
+	assert.Equal(t, http.StatusBadRequest, request(testAdminAPIKey, http.MethodGet, "/person/1/history", "").Code)
+}
+
+// TestAuditTrailAnonymous tests that changes made without authentication are
+// recorded as made by "anonymous", and that a person who was never changed has
+// an empty history.
+func TestAuditTrailAnonymous(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(1))
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+
+	rec := serve(store, http.MethodGet, "/person/"+people[0].ID.Hex()+"/history", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String())
+
+	rec = serve(store, http.MethodDelete, "/person/"+people[0].ID.Hex(), "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	events, err := store.GetPersonHistory(context.Background(), people[0].ID.Hex())
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, anonymousActor, events[0].Actor)
+		assert.Equal(t, AuditDelete, events[0].Action)
+		assert.NotEmpty(t, events[0].RequestID)
+	}
+}
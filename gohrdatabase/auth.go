@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIKeyHeader is the header a static API key is sent in.
+const APIKeyHeader = "X-API-Key"
+
+// An Identity is the authenticated caller of a request.
+type Identity struct {
+	// Subject identifies the caller: the name of an API key or the sub claim of a JWT.
+	Subject string `json:"subject"`
+	// Method is how the caller authenticated, "api_key" or "jwt", or "system"
+	// for the jobs the server runs itself.
+	Method string `json:"method"`
+	// Roles are the roles of the caller in the Policy.
+	Roles []string `json:"roles"`
+}
+
+// IdentityFromContext returns the caller of the request the context belongs to,
+// or nil if the request was not authenticated.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey).(*Identity)
+	return identity
+}
+
+// An APIKey is a static key, the name of the client it was issued to and their roles.
+type APIKey struct {
+	Name  string   `yaml:"name"`
+	Key   string   `yaml:"key"`
+	Roles []string `yaml:"roles"`
+}
+
+// An Authenticator identifies the caller of a request from an API key in the
+// X-API-Key header or a JWT bearer token in the Authorization header, and
+// holds the Policy their roles are checked against.
+type Authenticator struct {
+	// apiKeys maps the SHA-256 digest of each key to the key, so that keys
+	// are compared in constant time.
+	apiKeys map[[sha256.Size]byte]APIKey
+	jwt     *JWTVerifier
+	Policy  *Policy
+}
+
+// LoadAuthenticator returns an Authenticator for the API keys, JWT keys and
+// policy named in the config, or nil if no keys are, in which case requests
+// are neither authenticated nor authorized.
+func LoadAuthenticator(cfg *Config) (*Authenticator, error) {
+	if !cfg.hasCredentials() {
+		if cfg.Auth != AuthNone {
+			return nil, errors.New("no API keys or JWT keys are configured, set auth to none to open /person to everyone")
+		}
+
+		return nil, nil
+	}
+
+	auth := &Authenticator{apiKeys: make(map[[sha256.Size]byte]APIKey), Policy: DefaultPolicy()}
+	if cfg.PolicyFile != "" {
+		policy, err := LoadPolicy(cfg.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		auth.Policy = policy
+	}
+
+	if cfg.APIKeysFile != "" {
+		keys, err := loadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			auth.apiKeys[sha256.Sum256([]byte(key.Key))] = key
+		}
+	}
+
+	if cfg.JWTSecretFile != "" || cfg.JWTPublicKeyFile != "" {
+		secret, err := readKeyFile(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+
+		publicKey, err := readKeyFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if auth.jwt, err = NewJWTVerifier(bytes.TrimSpace(secret), publicKey, cfg.JWTIssuer, cfg.JWTAudience); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.JWTPublicKeyFile, err)
+		}
+	}
+
+	return auth, nil
+}
+
+// loadAPIKeys reads a YAML file with a list of API keys:
+//
+//	keys:
+//	  - name: payroll-sync
+//	    key: 3f7c9e...
+//	    roles: [editor]
+func loadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %w", err)
+	}
+
+	var file struct {
+		Keys []APIKey `yaml:"keys"`
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parsing API keys %s: %w", path, err)
+	}
+
+	for i, key := range file.Keys {
+		if key.Name == "" || len(key.Key) < 16 {
+			return nil, fmt.Errorf("API key %d in %s needs a name and a key of at least 16 characters", i, path)
+		}
+	}
+
+	return file.Keys, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT key: %w", err)
+	}
+
+	return data, nil
+}
+
+// Authenticate returns the identity of the caller of the request, or an error
+// if the request has no credentials or they are not valid.
+func (a *Authenticator) Authenticate(req *http.Request) (*Identity, error) {
+	if key := req.Header.Get(APIKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		if a.jwt == nil {
+			return nil, errors.New("bearer tokens are not accepted")
+		}
+
+		claims, err := a.jwt.Verify(strings.TrimSpace(token))
+		if err != nil {
+			return nil, err
+		}
+
+		return &Identity{Subject: claims.Subject, Method: "jwt", Roles: claims.Roles}, nil
+	}
+
+	return nil, errors.New("the request has no credentials")
+}
+
+func (a *Authenticator) authenticateAPIKey(key string) (*Identity, error) {
+	digest := sha256.Sum256([]byte(key))
+	for candidate, apiKey := range a.apiKeys {
+		if subtle.ConstantTimeCompare(digest[:], candidate[:]) == 1 {
+			return &Identity{Subject: apiKey.Name, Method: "api_key", Roles: apiKey.Roles}, nil
+		}
+	}
+
+	return nil, errors.New("invalid API key")
+}
+
+// Middleware rejects requests that cannot be authenticated with 401
+// Unauthorized, and adds the identity of the caller to the request context and
+// its logger otherwise.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, err := a.Authenticate(req)
+		if err != nil {
+			Logger(req.Context()).Info("authentication failed", "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gohrdatabase"`)
+			writeProblem(w, req, http.StatusUnauthorized, "Authentication is required: "+err.Error()+".")
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), identityKey, identity)
+		ctx = context.WithValue(ctx, loggerKey, Logger(ctx).With("subject", identity.Subject))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
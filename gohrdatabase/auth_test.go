@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The API keys accepted by newTestAuthenticator, one for each role of the default policy.
+const (
+	testAPIKey       = "test-key-0123456789"
+	testViewerAPIKey = "viewer-key-0123456789"
+	testAdminAPIKey  = "admin-key-0123456789"
+)
+
+// newTestAuthenticator returns an Authenticator with the default policy,
+// accepting testAPIKey as "payroll-sync" with the editor role, testViewerAPIKey
+// and testAdminAPIKey, and HS256 tokens signed with testSecret.
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.APIKeysFile = filepath.Join(dir, "keys.yaml")
+	cfg.JWTSecretFile = filepath.Join(dir, "secret")
+	assert.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte("keys:\n"+
+		"  - {name: payroll-sync, key: "+testAPIKey+", roles: [editor]}\n"+
+		"  - {name: reporting, key: "+testViewerAPIKey+", roles: [viewer]}\n"+
+		"  - {name: hr-team, key: "+testAdminAPIKey+", roles: [hr-admin]}\n"), 0o600))
+	assert.NoError(t, os.WriteFile(cfg.JWTSecretFile, append(testSecret, '\n'), 0o600))
+
+	auth, err := LoadAuthenticator(cfg)
+	assert.NoError(t, err)
+	return auth
+}
+
+// TestLoadAuthenticator tests that authentication is only disabled by auth
+// none, that it is required without keys, and that bad key files are rejected.
+func TestLoadAuthenticator(t *testing.T) {
+	_, err := LoadAuthenticator(DefaultConfig())
+	assert.ErrorContains(t, err, "set auth to none")
+
+	cfg := DefaultConfig()
+	cfg.Auth = AuthNone
+	auth, err := LoadAuthenticator(cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	dir := t.TempDir()
+	tests := []struct {
+		Name    string
+		Keys    string
+		Missing bool
+	}{
+		{"missing_file", "", true},
+		{"unknown_field", "keys:\n  - name: a\n    secret: 0123456789abcdef\n", false},
+		{"short_key", "keys:\n  - name: a\n    key: short\n", false},
+		{"no_name", "keys:\n  - key: 0123456789abcdef\n", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.APIKeysFile = filepath.Join(dir, tc.Name+".yaml")
+			if !tc.Missing {
+				assert.NoError(t, os.WriteFile(cfg.APIKeysFile, []byte(tc.Keys), 0o600))
+			}
+
+			_, err := LoadAuthenticator(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestAuthMiddleware tests that the caller is identified by an API key or a bearer token.
+func TestAuthMiddleware(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	var identity *Identity
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity = IdentityFromContext(req.Context())
+	}))
+
+	tests := []struct {
+		Name     string
+		Header   string
+		Value    string
+		Status   int
+		Identity *Identity
+	}{
+		{"api_key", APIKeyHeader, testAPIKey, http.StatusOK, &Identity{Subject: "payroll-sync", Method: "api_key", Roles: []string{"editor"}}},
+		{"bearer", "Authorization", "Bearer " + signJWT(t, "HS256", testSecret, map[string]interface{}{"sub": "alice", "roles": []string{"viewer"}, "exp": time.Now().Add(time.Minute).Unix()}), http.StatusOK, &Identity{Subject: "alice", Method: "jwt", Roles: []string{"viewer"}}},
+		{"no_credentials", "", "", http.StatusUnauthorized, nil},
+		{"wrong_api_key", APIKeyHeader, "wrong-key-0123456789", http.StatusUnauthorized, nil},
+		{"bad_token", "Authorization", "Bearer " + signJWT(t, "HS256", []byte("wrong"), map[string]interface{}{"sub": "alice"}), http.StatusUnauthorized, nil},
+		{"basic_auth", "Authorization", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			identity = nil
+			req := httptest.NewRequest(http.MethodGet, "/person", nil)
+			if tc.Header != "" {
+				req.Header.Set(tc.Header, tc.Value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.Status, rec.Code)
+			assert.Equal(t, tc.Identity, identity)
+			if tc.Status == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="gohrdatabase"`, rec.Header().Get("WWW-Authenticate"))
+				assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// TestAuthRoutes tests that the /person routes require authentication and the health routes do not.
+func TestAuthRoutes(t *testing.T) {
+	router := NewRouter(newFakeStore(), DefaultConfig(), newTestAuthenticator(t))
+
+	for _, target := range []string{"/person", "/person/1"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, target)
+
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(APIKeyHeader, testAPIKey)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, target)
+	}
+
+	for _, target := range []string{"/healthz", "/readyz", "/status", "/metrics"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, target)
+	}
+}
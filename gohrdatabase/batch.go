@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The kinds of operation of a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// A BatchOperation is one operation of a batch: the creation of a person, or
+// the update or deletion of the person with ID. An update or delete with a
+// Version is only applied to that version of the person, as with an If-Match
+// header.
+type BatchOperation struct {
+	Op      string  `json:"op"`
+	ID      string  `json:"id,omitempty"`
+	Version int64   `json:"version,omitempty"`
+	Person  *Person `json:"person,omitempty"`
+}
+
+// versions returns the versions the person must be at for the operation, none
+// if it has no Version.
+func (op BatchOperation) versions() []int64 {
+	if op.Version == 0 {
+		return nil
+	}
+
+	return []int64{op.Version}
+}
+
+// A BatchResult is the outcome of a BatchOperation: the person as the
+// operation left them, or the error it failed with.
+type BatchResult struct {
+	Person *Person
+	Err    error
+}
+
+// Validate checks that the operation has what its kind needs: a create a valid
+// person, an update an id and a valid person, and a delete an id and no person.
+func (op BatchOperation) Validate() error {
+	switch op.Op {
+	case BatchCreate:
+		if op.ID != "" || op.Version != 0 {
+			return fmt.Errorf("%w: a create takes no id or version", ErrValidation)
+		}
+	case BatchUpdate:
+		if op.ID == "" {
+			return fmt.Errorf("%w: an update needs an id", ErrValidation)
+		}
+	case BatchDelete:
+		if op.ID == "" {
+			return fmt.Errorf("%w: a delete needs an id", ErrValidation)
+		}
+
+		if op.Person != nil {
+			return fmt.Errorf("%w: a delete takes no person", ErrValidation)
+		}
+
+		return nil
+	default:
+		return unknownBatchOperation(op)
+	}
+
+	if op.Person == nil {
+		return fmt.Errorf("%w: a %s needs a person", ErrValidation, op.Op)
+	}
+
+	return ValidatePerson(*op.Person)
+}
+
+// unknownBatchOperation returns the error of an operation of an unknown kind.
+func unknownBatchOperation(op BatchOperation) error {
+	return fmt.Errorf("%w: op %q must be create, update or delete", ErrValidation, op.Op)
+}
+
+// abortBatch fails every result of an all-or-nothing batch with ErrBatchAborted,
+// except the one at index failed, which keeps the error it failed with.
+func abortBatch(results []BatchResult, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+}
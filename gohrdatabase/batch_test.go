@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestBatchOperationValidate tests that each kind of operation has what it needs.
+func TestBatchOperationValidate(t *testing.T) {
+	person := &Person{Firstname: "Emma", Lastname: "Jones"}
+	tests := []struct {
+		Name  string
+		Op    BatchOperation
+		Valid bool
+	}{
+		{"create", BatchOperation{Op: BatchCreate, Person: person}, true},
+		{"create_with_id", BatchOperation{Op: BatchCreate, ID: "1", Person: person}, false},
+		{"create_without_person", BatchOperation{Op: BatchCreate}, false},
+		{"create_invalid_person", BatchOperation{Op: BatchCreate, Person: &Person{Firstname: "Emma"}}, false},
+		{"update", BatchOperation{Op: BatchUpdate, ID: "1", Version: 2, Person: person}, true},
+		{"update_without_id", BatchOperation{Op: BatchUpdate, Person: person}, false},
+		{"update_without_person", BatchOperation{Op: BatchUpdate, ID: "1"}, false},
+		{"delete", BatchOperation{Op: BatchDelete, ID: "1"}, true},
+		{"delete_with_version", BatchOperation{Op: BatchDelete, ID: "1", Version: 2}, true},
+		{"delete_without_id", BatchOperation{Op: BatchDelete}, false},
+		{"delete_with_person", BatchOperation{Op: BatchDelete, ID: "1", Person: person}, false},
+		{"unknown", BatchOperation{Op: "upsert", ID: "1", Person: person}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Op.Validate()
+			if tc.Valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// newBatchStore returns a MemoryStore with two people, and their ids.
+func newBatchStore(t *testing.T) (*MemoryStore, string, string) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith"}, {Firstname: "Emma", Lastname: "Jones"}})
+	people, err := store.GetAllPeople(context.Background(), nil, &FindOptions{Sort: []SortField{{Path: "firstname"}}})
+	assert.NoError(t, err)
+	return store, people[1].ID.Hex(), people[0].ID.Hex()
+}
+
+// serveBatch posts the operations to /person:batch and decodes the results.
+func serveBatch(t *testing.T, store PersonStore, target, body string) []batchItem {
+	rec := serve(store, http.MethodPost, target, body)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var items []batchItem
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&items))
+	return items
+}
+
+// TestBatchPeople tests that each operation of a batch is applied on its own,
+// and that the failing ones are reported with their status.
+func TestBatchPeople(t *testing.T) {
+	store, john, emma := newBatchStore(t)
+
+	items := serveBatch(t, store, "/person:batch", `[
+		{"op":"create","person":{"firstname":"Jane","lastname":"Doe"}},
+		{"op":"create","person":{"firstname":"Jane"}},
+		{"op":"update","id":"`+john+`","person":{"firstname":"Jack","lastname":"Smith"}},
+		{"op":"update","id":"`+emma+`","version":7,"person":{"firstname":"Emily","lastname":"Jones"}},
+		{"op":"delete","id":"`+john+`","version":1},
+		{"op":"delete","id":"`+emma+`","version":1},
+		{"op":"delete","id":"650000000000000000000000"},
+		{"op":"delete","id":"1"},
+		{"op":"upsert","id":"`+john+`"}
+	]`)
+
+	statuses := make([]int, len(items))
+	for i, item := range items {
+		statuses[i] = item.Status
+	}
+
+	assert.Equal(t, []int{200, 422, 200, 412, 412, 200, 404, 400, 422}, statuses)
+	assert.NotEmpty(t, items[0].ID)
+	assert.Equal(t, int64(1), items[0].Version)
+	assert.Equal(t, "lastname", items[1].InvalidParams[0].Name)
+	assert.Equal(t, john, items[2].ID)
+	assert.Equal(t, int64(2), items[2].Version)
+	assert.Contains(t, items[3].Error, "version")
+	assert.Contains(t, items[4].Error, "version")
+	assert.Equal(t, emma, items[5].ID)
+	assert.Equal(t, int64(2), items[5].Version)
+
+	created, err := store.GetPersonByObjectId(context.Background(), items[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", created.Firstname)
+
+	updated, err := store.GetPersonByObjectId(context.Background(), john)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jack", updated.Firstname)
+
+	_, err = store.GetPersonByObjectId(context.Background(), emma)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	events, err := store.GetPersonHistory(context.Background(), emma)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, AuditDelete, events[0].Action)
+	}
+}
+
+// TestBatchPeopleAtomic tests that an atomic batch is applied as a whole, or
+// not at all if any of its operations fails.
+func TestBatchPeopleAtomic(t *testing.T) {
+	store, john, emma := newBatchStore(t)
+	count := func() int64 {
+		count, err := store.CountPeople(context.Background(), notDeleted(nil))
+		assert.NoError(t, err)
+		return count
+	}
+
+	items := serveBatch(t, store, "/person:batch?atomic=true", `[
+		{"op":"create","person":{"firstname":"Jane","lastname":"Doe"}},
+		{"op":"update","id":"`+john+`","person":{"firstname":"Jack","lastname":"Smith"}},
+		{"op":"delete","id":"`+emma+`"},
+		{"op":"delete","id":"`+emma+`"}
+	]`)
+
+	if assert.Len(t, items, 4) {
+		assert.Equal(t, http.StatusFailedDependency, items[0].Status)
+		assert.Empty(t, items[0].ID)
+		assert.Equal(t, http.StatusFailedDependency, items[1].Status)
+		assert.Equal(t, http.StatusFailedDependency, items[2].Status)
+		assert.Equal(t, http.StatusNotFound, items[3].Status)
+	}
+
+	assert.Equal(t, int64(2), count())
+	person, err := store.GetPersonByObjectId(context.Background(), john)
+	assert.NoError(t, err)
+	assert.Equal(t, "John", person.Firstname)
+	assert.Equal(t, int64(1), person.Version)
+
+	for _, id := range []string{john, emma} {
+		events, err := store.GetPersonHistory(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	}
+
+	people, err := store.GetAllPeople(context.Background(), bson.M{"firstname": "Jane"}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, people)
+
+	// An invalid operation aborts the batch before it reaches the store.
+	items = serveBatch(t, store, "/person:batch?atomic=true", `[
+		{"op":"delete","id":"`+emma+`"},
+		{"op":"create","person":{"firstname":"Jane"}}
+	]`)
+
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, http.StatusFailedDependency, items[0].Status)
+		assert.Equal(t, http.StatusUnprocessableEntity, items[1].Status)
+	}
+
+	assert.Equal(t, int64(2), count())
+
+	items = serveBatch(t, store, "/person:batch?atomic=true", `[
+		{"op":"create","person":{"firstname":"Jane","lastname":"Doe"}},
+		{"op":"update","id":"`+john+`","version":1,"person":{"firstname":"Jack","lastname":"Smith"}},
+		{"op":"delete","id":"`+emma+`"}
+	]`)
+
+	for _, item := range items {
+		assert.Equal(t, http.StatusOK, item.Status)
+	}
+
+	assert.Equal(t, int64(2), count())
+	person, err = store.GetPersonByObjectId(context.Background(), john)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jack", person.Firstname)
+}
+
+// TestBatchPeopleRequest tests that malformed batches are rejected as a whole.
+func TestBatchPeopleRequest(t *testing.T) {
+	store := newFakeStore()
+	cfg := DefaultConfig()
+	cfg.BatchLimit = 2
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		NewRouter(store, cfg, nil).ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	create := `{"op":"create","person":{"firstname":"Jane","lastname":"Doe"}}`
+	tests := []struct {
+		Name   string
+		Method string
+		Target string
+		Body   string
+		Status int
+	}{
+		{"valid", http.MethodPost, "/person:batch?atomic=true", "[" + create + "," + create + "]", http.StatusOK},
+		{"not_an_array", http.MethodPost, "/person:batch", create, http.StatusBadRequest},
+		{"unknown_member", http.MethodPost, "/person:batch", `[{"op":"create","upsert":true}]`, http.StatusBadRequest},
+		{"empty", http.MethodPost, "/person:batch", "[]", http.StatusBadRequest},
+		{"too_large", http.MethodPost, "/person:batch", "[" + create + "," + create + "," + create + "]", http.StatusRequestEntityTooLarge},
+		{"invalid_atomic", http.MethodPost, "/person:batch?atomic=yes", "[" + create + "]", http.StatusBadRequest},
+		{"get", http.MethodGet, "/person:batch", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Status, request(tc.Method, tc.Target, tc.Body).Code)
+		})
+	}
+
+	assert.Len(t, store.batch, 2)
+	assert.True(t, store.atomic)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The storage modes. StorageAuto uses MongoDB if it can be reached, and falls
+// back to in-memory storage otherwise.
+const (
+	StorageAuto   = "auto"
+	StorageMongo  = "mongo"
+	StorageMemory = "memory"
+)
+
+// The authentication modes. AuthRequired refuses to start without any API
+// keys or JWT keys, and AuthNone opens the /person routes to everyone.
+const (
+	AuthRequired = "required"
+	AuthNone     = "none"
+)
+
+// A Config holds the settings of the server.
+//
+// Each setting can be given in an optional YAML config file, an environment
+// variable or a command line flag, see configSettings. Flags take precedence
+// over environment variables, which take precedence over the config file,
+// which takes precedence over the defaults from DefaultConfig. The config file
+// is given with the -config flag or the HRDB_CONFIG environment variable.
+type Config struct {
+	ListenAddr string `yaml:"listen_addr"`
+	MongoURI   string `yaml:"mongo_uri"`
+	Database   string `yaml:"database"`
+	Collection string `yaml:"collection"`
+	// AuditCollection is the MongoDB collection the audit events of the changes to people are kept in.
+	AuditCollection string        `yaml:"audit_collection"`
+	Storage         string        `yaml:"storage"`
+	SeedCount       int           `yaml:"seed_count"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	// StoreTimeout is how long a request may spend in the store before it fails
+	// with 504 Gateway Timeout.
+	StoreTimeout time.Duration `yaml:"store_timeout"`
+	// ShutdownTimeout is how long in-flight requests may take to finish on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// StreamTimeout is how long a streamed response may take, in place of the
+	// StoreTimeout. The WriteTimeout is restarted each time it is flushed.
+	StreamTimeout time.Duration `yaml:"stream_timeout"`
+	// Auth is the authentication mode. Open access has to be asked for with
+	// AuthNone, as no credentials are configured by default.
+	Auth string `yaml:"auth"`
+	// APIKeysFile is a YAML file of the API keys accepted in the X-API-Key header.
+	APIKeysFile string `yaml:"api_keys_file"`
+	// JWTSecretFile holds the secret HS256 bearer tokens are signed with.
+	JWTSecretFile string `yaml:"jwt_secret_file"`
+	// JWTPublicKeyFile holds the PEM encoded RSA public key RS256 bearer tokens are signed with.
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	// PolicyFile is a YAML file of the roles callers may have, see DefaultPolicy.
+	PolicyFile string `yaml:"policy_file"`
+	// JWTIssuer and JWTAudience, if set, must match the iss and aud claims of bearer tokens.
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	JWTAudience string `yaml:"jwt_audience"`
+	// LogLevel is the minimum level of the log lines written: debug, info, warn or error.
+	LogLevel string `yaml:"log_level"`
+	// DeletedRetention is how long deleted people are kept, and can be restored,
+	// before they are purged. Deleted people are kept forever when it is zero.
+	DeletedRetention time.Duration `yaml:"deleted_retention"`
+	// PurgeInterval is how often people past the DeletedRetention are purged.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+	// BatchLimit is the largest number of operations a batch may have.
+	BatchLimit int `yaml:"batch_limit"`
+	// MemorySnapshot is the file the in-memory store is loaded from on start and
+	// saved to on shutdown. The in-memory store is not persisted when it is not set.
+	MemorySnapshot string `yaml:"memory_snapshot"`
+}
+
+// A configSetting maps a Config field to its environment variable and flag.
+type configSetting struct {
+	Env   string
+	Flag  string
+	Usage string
+	Set   func(c *Config, value string) error
+}
+
+// DefaultConfig returns the config used when no other settings are given.
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:       ":12345",
+		MongoURI:         "mongodb://localhost:27017",
+		Database:         "hrdatabase",
+		Collection:       "people",
+		AuditCollection:  "people_audit",
+		Storage:          StorageAuto,
+		SeedCount:        100,
+		ConnectTimeout:   10 * time.Second,
+		ReadTimeout:      15 * time.Second,
+		WriteTimeout:     15 * time.Second,
+		StoreTimeout:     5 * time.Second,
+		ShutdownTimeout:  30 * time.Second,
+		StreamTimeout:    5 * time.Minute,
+		Auth:             AuthRequired,
+		LogLevel:         "info",
+		DeletedRetention: 30 * 24 * time.Hour,
+		PurgeInterval:    time.Hour,
+		BatchLimit:       1000,
+	}
+}
+
+func configSettings() []configSetting {
+	return []configSetting{
+		{"HRDB_LISTEN_ADDR", "listen", "`address` to listen on", func(c *Config, v string) error {
+			c.ListenAddr = v
+			return nil
+		}},
+		{"HRDB_MONGO_URI", "mongo-uri", "MongoDB connection `uri`", func(c *Config, v string) error {
+			c.MongoURI = v
+			return nil
+		}},
+		{"HRDB_DATABASE", "database", "MongoDB database `name`", func(c *Config, v string) error {
+			c.Database = v
+			return nil
+		}},
+		{"HRDB_COLLECTION", "collection", "MongoDB collection `name`", func(c *Config, v string) error {
+			c.Collection = v
+			return nil
+		}},
+		{"HRDB_AUDIT_COLLECTION", "audit-collection", "MongoDB collection `name` of the audit trail", func(c *Config, v string) error {
+			c.AuditCollection = v
+			return nil
+		}},
+		{"HRDB_STORAGE", "storage", "storage `mode`: auto, mongo or memory", func(c *Config, v string) error {
+			c.Storage = v
+			return nil
+		}},
+		{"HRDB_SEED_COUNT", "seed-count", "`number` of people to seed an empty database with", func(c *Config, v string) error {
+			return parseInt(v, &c.SeedCount)
+		}},
+		{"HRDB_CONNECT_TIMEOUT", "connect-timeout", "`duration` to wait for MongoDB to connect", func(c *Config, v string) error {
+			return parseDuration(v, &c.ConnectTimeout)
+		}},
+		{"HRDB_READ_TIMEOUT", "read-timeout", "`duration` to wait for a request to be read", func(c *Config, v string) error {
+			return parseDuration(v, &c.ReadTimeout)
+		}},
+		{"HRDB_WRITE_TIMEOUT", "write-timeout", "`duration` to wait for a response to be written", func(c *Config, v string) error {
+			return parseDuration(v, &c.WriteTimeout)
+		}},
+		{"HRDB_STORE_TIMEOUT", "store-timeout", "`duration` a request may spend in the store", func(c *Config, v string) error {
+			return parseDuration(v, &c.StoreTimeout)
+		}},
+		{"HRDB_SHUTDOWN_TIMEOUT", "shutdown-timeout", "`duration` to wait for in-flight requests on shutdown", func(c *Config, v string) error {
+			return parseDuration(v, &c.ShutdownTimeout)
+		}},
+		{"HRDB_STREAM_TIMEOUT", "stream-timeout", "`duration` a streamed response may take", func(c *Config, v string) error {
+			return parseDuration(v, &c.StreamTimeout)
+		}},
+		{"HRDB_AUTH", "auth", "authentication `mode`: required, or none to open /person to everyone", func(c *Config, v string) error {
+			c.Auth = v
+			return nil
+		}},
+		{"HRDB_API_KEYS_FILE", "api-keys-file", "`path` of a YAML file of accepted API keys", func(c *Config, v string) error {
+			c.APIKeysFile = v
+			return nil
+		}},
+		{"HRDB_JWT_SECRET_FILE", "jwt-secret-file", "`path` of the secret of HS256 bearer tokens", func(c *Config, v string) error {
+			c.JWTSecretFile = v
+			return nil
+		}},
+		{"HRDB_JWT_PUBLIC_KEY_FILE", "jwt-public-key-file", "`path` of the PEM RSA public key of RS256 bearer tokens", func(c *Config, v string) error {
+			c.JWTPublicKeyFile = v
+			return nil
+		}},
+		{"HRDB_POLICY_FILE", "policy-file", "`path` of a YAML file of the roles of callers", func(c *Config, v string) error {
+			c.PolicyFile = v
+			return nil
+		}},
+		{"HRDB_JWT_ISSUER", "jwt-issuer", "required iss `claim` of bearer tokens", func(c *Config, v string) error {
+			c.JWTIssuer = v
+			return nil
+		}},
+		{"HRDB_JWT_AUDIENCE", "jwt-audience", "required aud `claim` of bearer tokens", func(c *Config, v string) error {
+			c.JWTAudience = v
+			return nil
+		}},
+		{"HRDB_LOG_LEVEL", "log-level", "minimum `level` to log: debug, info, warn or error", func(c *Config, v string) error {
+			c.LogLevel = v
+			return nil
+		}},
+		{"HRDB_DELETED_RETENTION", "deleted-retention", "`duration` deleted people are kept before they are purged, 0 to keep them", func(c *Config, v string) error {
+			return parseDuration(v, &c.DeletedRetention)
+		}},
+		{"HRDB_PURGE_INTERVAL", "purge-interval", "`duration` between purges of deleted people", func(c *Config, v string) error {
+			return parseDuration(v, &c.PurgeInterval)
+		}},
+		{"HRDB_BATCH_LIMIT", "batch-limit", "largest `number` of operations in a batch", func(c *Config, v string) error {
+			return parseInt(v, &c.BatchLimit)
+		}},
+		{"HRDB_MEMORY_SNAPSHOT", "memory-snapshot", "`path` of the file the in-memory store is saved to", func(c *Config, v string) error {
+			c.MemorySnapshot = v
+			return nil
+		}},
+	}
+}
+
+// LoadConfig builds the config from the defaults, the config file, the
+// environment and the command line arguments, in increasing order of
+// precedence, and validates it.
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	settings := configSettings()
+
+	fs := flag.NewFlagSet("gohrdatabase", flag.ContinueOnError)
+	configPath := fs.String("config", "", "`path` of a YAML config file (HRDB_CONFIG)")
+	flagValues := make(map[string]*string)
+	for _, setting := range settings {
+		flagValues[setting.Flag] = fs.String(setting.Flag, "", fmt.Sprintf("%s (%s)", setting.Usage, setting.Env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := DefaultConfig()
+
+	path := *configPath
+	if path == "" {
+		path = getenv("HRDB_CONFIG")
+	}
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, setting := range settings {
+		if value := getenv(setting.Env); value != "" {
+			if err := setting.Set(cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", setting.Env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, setting := range settings {
+			if err == nil && setting.Flag == f.Name {
+				if setErr := setting.Set(cfg, *flagValues[f.Name]); setErr != nil {
+					err = fmt.Errorf("-%s: %w", f.Name, setErr)
+				}
+			}
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile overlays the settings in the YAML file onto the config.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks that the config is complete and consistent.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("listen address %q is not a host:port", c.ListenAddr))
+	}
+
+	switch c.Storage {
+	case StorageAuto, StorageMongo, StorageMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("storage %q must be auto, mongo or memory", c.Storage))
+	}
+
+	if c.Storage != StorageMemory {
+		if !strings.HasPrefix(c.MongoURI, "mongodb://") && !strings.HasPrefix(c.MongoURI, "mongodb+srv://") {
+			problems = append(problems, fmt.Sprintf("mongo uri %q must start with mongodb:// or mongodb+srv://", c.MongoURI))
+		}
+
+		if c.Database == "" {
+			problems = append(problems, "database is required")
+		}
+
+		if c.Collection == "" {
+			problems = append(problems, "collection is required")
+		}
+
+		if c.AuditCollection == "" {
+			problems = append(problems, "audit collection is required")
+		} else if c.AuditCollection == c.Collection {
+			problems = append(problems, "audit collection must differ from the collection")
+		}
+	}
+
+	switch c.Auth {
+	case AuthRequired:
+	case AuthNone:
+		if c.hasCredentials() {
+			problems = append(problems, "auth none cannot be combined with API keys or JWT keys")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("auth %q must be required or none", c.Auth))
+	}
+
+	if _, err := c.logLevel(); err != nil {
+		problems = append(problems, fmt.Sprintf("log level %q must be debug, info, warn or error", c.LogLevel))
+	}
+
+	if c.SeedCount < 0 {
+		problems = append(problems, "seed count cannot be negative")
+	}
+
+	timeouts := []struct {
+		Name  string
+		Value time.Duration
+	}{
+		{"connect timeout", c.ConnectTimeout},
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"store timeout", c.StoreTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+		{"stream timeout", c.StreamTimeout},
+		{"purge interval", c.PurgeInterval},
+	}
+
+	for _, timeout := range timeouts {
+		if timeout.Value <= 0 {
+			problems = append(problems, timeout.Name+" must be positive")
+		}
+	}
+
+	if c.DeletedRetention < 0 {
+		problems = append(problems, "deleted retention cannot be negative")
+	}
+
+	if c.BatchLimit <= 0 {
+		problems = append(problems, "batch limit must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// logLevel parses the LogLevel setting.
+func (c *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// NewLogger returns a logger writing JSON lines to w at the configured level.
+func (c *Config) NewLogger(w io.Writer) *slog.Logger {
+	level, _ := c.logLevel()
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+func parseInt(value string, target *int) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+
+	*target = i
+	return nil
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", value)
+	}
+
+	*target = d
+	return nil
+}
+
+// hasCredentials reports whether any API keys or JWT keys are configured.
+func (c *Config) hasCredentials() bool {
+	return c.APIKeysFile != "" || c.JWTSecretFile != "" || c.JWTPublicKeyFile != ""
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+// TestLoadConfigDefaults tests that LoadConfig returns the defaults when nothing is set.
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), cfg)
+}
+
+// TestLoadConfigExample tests that the example config file is valid.
+func TestLoadConfigExample(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-config", "config.example.yaml"}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), cfg)
+}
+
+// TestLoadConfigPrecedence tests that flags override the environment, which overrides the config file.
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("listen_addr: \":8000\"\ndatabase: filedb\ncollection: filepeople\nseed_count: 5\nconnect_timeout: 3s\n"), 0o600))
+
+	cfg, err := LoadConfig(
+		[]string{"-listen", ":9000", "-storage", "memory"},
+		env(map[string]string{
+			"HRDB_CONFIG":      path,
+			"HRDB_LISTEN_ADDR": ":8500",
+			"HRDB_DATABASE":    "envdb",
+			"HRDB_MONGO_URI":   "mongodb://db:27017",
+		}),
+	)
+
+	assert.NoError(t, err)
+	expected := DefaultConfig()
+	expected.ListenAddr = ":9000"
+	expected.MongoURI = "mongodb://db:27017"
+	expected.Database = "envdb"
+	expected.Collection = "filepeople"
+	expected.Storage = StorageMemory
+	expected.SeedCount = 5
+	expected.ConnectTimeout = 3 * time.Second
+	assert.Equal(t, expected, cfg)
+}
+
+// TestLoadConfigErrors tests that invalid settings are rejected.
+func TestLoadConfigErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("listen_port: 8000\n"), 0o600))
+
+	tests := []struct {
+		Name string
+		Args []string
+		Env  map[string]string
+	}{
+		{"unknown_flag", []string{"-port", "8000"}, nil},
+		{"unexpected_argument", []string{"serve"}, nil},
+		{"missing_file", []string{"-config", "missing.yaml"}, nil},
+		{"unknown_file_setting", []string{"-config", path}, nil},
+		{"invalid_listen_addr", []string{"-listen", "8000"}, nil},
+		{"invalid_storage", nil, map[string]string{"HRDB_STORAGE": "postgres"}},
+		{"invalid_mongo_uri", []string{"-mongo-uri", "localhost:27017"}, nil},
+		{"empty_collection", []string{"-collection", ""}, nil},
+		{"invalid_seed_count", nil, map[string]string{"HRDB_SEED_COUNT": "many"}},
+		{"negative_seed_count", []string{"-seed-count", "-1"}, nil},
+		{"invalid_timeout", []string{"-read-timeout", "15"}, nil},
+		{"zero_timeout", nil, map[string]string{"HRDB_WRITE_TIMEOUT": "0s"}},
+		{"zero_store_timeout", []string{"-store-timeout", "0s"}, nil},
+		{"zero_stream_timeout", nil, map[string]string{"HRDB_STREAM_TIMEOUT": "0s"}},
+		{"invalid_auth", []string{"-auth", "optional"}, nil},
+		{"auth_none_with_keys", []string{"-auth", "none", "-api-keys-file", "keys.yaml"}, nil},
+		{"invalid_log_level", nil, map[string]string{"HRDB_LOG_LEVEL": "verbose"}},
+		{"negative_deleted_retention", []string{"-deleted-retention", "-1h"}, nil},
+		{"zero_purge_interval", nil, map[string]string{"HRDB_PURGE_INTERVAL": "0s"}},
+		{"zero_batch_limit", []string{"-batch-limit", "0"}, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := LoadConfig(tc.Args, env(tc.Env))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestConnectDatabaseMemory tests that the memory storage mode seeds the configured number of people.
+func TestConnectDatabaseMemory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage = StorageMemory
+	cfg.SeedCount = 7
+
+	store := ConnectDatabase(context.Background(), cfg)
+	count, err := store.CountPeople(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+}
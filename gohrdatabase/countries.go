@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// countryCodes is the set of officially assigned ISO 3166-1 alpha-2 country codes.
+var countryCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ
+		BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+		CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ
+		DE DJ DK DM DO DZ
+		EC EE EG EH ER ES ET
+		FI FJ FK FM FO FR
+		GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY
+		HK HM HN HR HT HU
+		ID IE IL IM IN IO IQ IR IS IT
+		JE JM JO JP
+		KE KG KH KI KM KN KP KR KW KY KZ
+		LA LB LC LI LK LR LS LT LU LV LY
+		MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ
+		NA NC NE NF NG NI NL NO NP NR NU NZ
+		OM
+		PA PE PF PG PH PK PL PM PN PR PS PT PW PY
+		QA
+		RE RO RS RU RW
+		SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ
+		TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ
+		UA UG UM US UY UZ
+		VA VC VE VG VI VN VU
+		WF WS
+		YE YT
+		ZA ZM ZW`) {
+		codes[code] = true
+	}
+
+	return codes
+}()
+
+// legacyCountryNames maps the country names the mock data held before
+// countries were validated to their ISO 3166-1 alpha-2 codes, so that the
+// people stored with them can be migrated.
+var legacyCountryNames = map[string]string{
+	"UK": "GB", "France": "FR", "USA": "US", "Japan": "JP", "Germany": "DE",
+	"Australia": "AU", "Canada": "CA", "Spain": "ES", "Italy": "IT", "Russia": "RU",
+	"China": "CN", "UAE": "AE", "Singapore": "SG", "Hong Kong": "HK", "India": "IN",
+	"Brazil": "BR", "South Africa": "ZA", "Thailand": "TH", "South Korea": "KR",
+	"Netherlands": "NL", "Sweden": "SE", "Norway": "NO", "Finland": "FI", "Austria": "AT",
+}
+
+// migrateCountry replaces a legacy country name of the person with its code.
+func migrateCountry(person *Person) bool {
+	if person.Location == nil {
+		return false
+	}
+
+	code, ok := legacyCountryNames[person.Location.Country]
+	if ok {
+		person.Location.Country = code
+	}
+
+	return ok
+}
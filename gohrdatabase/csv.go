@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A csvColumn is a column of the CSV export and import of people. Name is the
+// dotted JSON path of the field.
+type csvColumn struct {
+	Name string
+	// Aliases are the other names the column is recognised by on import.
+	Aliases []string
+	Get     func(person *Person) string
+	// Set sets the field from the value on import, or is nil if the column is
+	// ignored on import.
+	Set func(person *Person, value string)
+}
+
+// csvColumns returns the columns of the CSV export, in order. The id and
+// version are exported so that a file can be edited and imported again.
+func csvColumns() []csvColumn {
+	return []csvColumn{
+		{
+			Name: "id",
+			Get: func(person *Person) string {
+				if person.ID.IsZero() {
+					return ""
+				}
+
+				return person.ID.Hex()
+			},
+		},
+		{
+			Name: "version",
+			Get: func(person *Person) string {
+				return strconv.FormatInt(person.Version, 10)
+			},
+		},
+		{
+			Name: "firstname",
+			Get: func(person *Person) string {
+				return person.Firstname
+			},
+			Set: func(person *Person, value string) {
+				person.Firstname = value
+			},
+		},
+		{
+			Name: "lastname",
+			Get: func(person *Person) string {
+				return person.Lastname
+			},
+			Set: func(person *Person, value string) {
+				person.Lastname = value
+			},
+		},
+		{
+			Name:    "location.city",
+			Aliases: []string{"city"},
+			Get: func(person *Person) string {
+				if person.Location == nil {
+					return ""
+				}
+
+				return person.Location.City
+			},
+			Set: func(person *Person, value string) {
+				if value != "" {
+					personLocation(person).City = value
+				}
+			},
+		},
+		{
+			Name:    "location.country",
+			Aliases: []string{"country"},
+			Get: func(person *Person) string {
+				if person.Location == nil {
+					return ""
+				}
+
+				return person.Location.Country
+			},
+			Set: func(person *Person, value string) {
+				if value != "" {
+					personLocation(person).Country = value
+				}
+			},
+		},
+		{
+			Name: "employee_number",
+			Get: func(person *Person) string {
+				return person.EmployeeNumber
+			},
+			Set: func(person *Person, value string) {
+				person.EmployeeNumber = value
+			},
+		},
+		{
+			Name: "email",
+			Get: func(person *Person) string {
+				return person.Email
+			},
+			Set: func(person *Person, value string) {
+				person.Email = value
+			},
+		},
+		{
+			Name: "phone",
+			Get: func(person *Person) string {
+				return person.Phone
+			},
+			Set: func(person *Person, value string) {
+				person.Phone = value
+			},
+		},
+		employmentColumn("job_title", func(employment *Employment) *string { return &employment.JobTitle }),
+		employmentColumn("department", func(employment *Employment) *string { return &employment.Department }),
+		employmentColumn("manager_id", func(employment *Employment) *string { return &employment.ManagerID }),
+		employmentColumn("start_date", func(employment *Employment) *string { return &employment.StartDate }),
+		employmentColumn("end_date", func(employment *Employment) *string { return &employment.EndDate }),
+		employmentColumn("status", func(employment *Employment) *string { return &employment.Status }),
+		employmentColumn("salary_band", func(employment *Employment) *string { return &employment.SalaryBand }),
+		{
+			Name: "deleted_at",
+			Get: func(person *Person) string {
+				if person.DeletedAt == nil {
+					return ""
+				}
+
+				return person.DeletedAt.Format(time.RFC3339Nano)
+			},
+		},
+	}
+}
+
+// employmentColumn returns the column of a field of the employment of a
+// person, which is also recognised by its name without the employment prefix.
+// field returns a pointer to the field of the employment.
+func employmentColumn(name string, field func(employment *Employment) *string) csvColumn {
+	return csvColumn{
+		Name:    "employment." + name,
+		Aliases: []string{name},
+		Get: func(person *Person) string {
+			if person.Employment == nil {
+				return ""
+			}
+
+			return *field(person.Employment)
+		},
+		Set: func(person *Person, value string) {
+			if value != "" {
+				*field(personEmployment(person)) = value
+			}
+		},
+	}
+}
+
+// personEmployment returns the employment of the person, setting an empty one if they have none.
+func personEmployment(person *Person) *Employment {
+	if person.Employment == nil {
+		person.Employment = &Employment{}
+	}
+
+	return person.Employment
+}
+
+// personLocation returns the location of the person, setting an empty one if they have none.
+func personLocation(person *Person) *Location {
+	if person.Location == nil {
+		person.Location = &Location{}
+	}
+
+	return person.Location
+}
+
+// newCSVStream returns a peopleStream writing people to w as CSV, with a
+// header row of the column names.
+func newCSVStream(w http.ResponseWriter, writeTimeout time.Duration) *peopleStream {
+	columns := csvColumns()
+	return newPeopleStream(w, &csvEncoder{writer: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}, writeTimeout)
+}
+
+// A csvEncoder encodes each person as a row of the columns.
+type csvEncoder struct {
+	writer  *csv.Writer
+	columns []csvColumn
+	record  []string
+}
+
+func (e *csvEncoder) setHeaders(header http.Header) {
+	header.Set("Content-Type", "text/csv; charset=utf-8")
+	header.Set("Content-Disposition", `attachment; filename="people.csv"`)
+}
+
+func (e *csvEncoder) begin() error {
+	for i, column := range e.columns {
+		e.record[i] = column.Name
+	}
+
+	return e.writer.Write(e.record)
+}
+
+func (e *csvEncoder) encode(person *Person) error {
+	for i, column := range e.columns {
+		e.record[i] = escapeCSVFormula(column.Get(person))
+	}
+
+	return e.writer.Write(e.record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// escapeCSVFormula prefixes a value that a spreadsheet would run as a formula
+// with a single quote, which spreadsheets hide and unescapeCSVFormula removes.
+// E.164 phone numbers are left alone, as their plus sign and digits cannot run
+// anything.
+func escapeCSVFormula(value string) string {
+	if isCSVFormula(value) && !phonePattern.MatchString(value) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// unescapeCSVFormula removes the quote escapeCSVFormula adds to a value.
+func unescapeCSVFormula(value string) string {
+	if unquoted, ok := strings.CutPrefix(value, "'"); ok && isCSVFormula(unquoted) {
+		return unquoted
+	}
+
+	return value
+}
+
+// isCSVFormula reports whether escapeCSVFormula escapes the value: if it starts
+// like a formula, or is a quote before a value that does, so that unescaping
+// never changes a value that was not escaped.
+func isCSVFormula(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	if value[0] == '\'' {
+		return isCSVFormula(value[1:])
+	}
+
+	return strings.ContainsRune("=+-@\t\r", rune(value[0]))
+}
+
+// A csvRow is a row of an imported CSV file: the operation it asks for, or the
+// error it cannot be read with. Line is its line number in the file, where the
+// header row is line 1. The operation of an update has no person, and Patch
+// holds the columns of the file to merge into the stored person instead.
+type csvRow struct {
+	Line  int
+	Op    BatchOperation
+	Patch MergePatch
+	Err   error
+}
+
+// A csvImporter reads the rows of a CSV file of people, one at a time.
+type csvImporter struct {
+	reader *csv.Reader
+	// columns are the columns of the file, in order, nil for the ignored ones.
+	columns []*csvColumn
+	id      int
+	version int
+}
+
+// newCSVImporter returns a csvImporter reading r, after reading its header row.
+// The header names the column of each field, in any order and case, and must
+// have a firstname and a lastname column unless it has an id column, as only
+// updates may leave fields out. A UTF-8 byte order mark is skipped.
+func newCSVImporter(r io.Reader) (*csvImporter, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the CSV file has no header row")
+	} else if err != nil {
+		return nil, fmt.Errorf("reading the header row: %w", err)
+	}
+
+	importer := &csvImporter{reader: reader, columns: make([]*csvColumn, len(header)), id: -1, version: -1}
+	names := make(map[string]*csvColumn)
+	columns := csvColumns()
+	for i := range columns {
+		names[columns[i].Name] = &columns[i]
+		for _, alias := range columns[i].Aliases {
+			names[alias] = &columns[i]
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+
+		column, ok := names[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+
+		if seen[column.Name] {
+			return nil, fmt.Errorf("the CSV column %q is given twice", column.Name)
+		}
+
+		seen[column.Name] = true
+		switch column.Name {
+		case "id":
+			importer.id = i
+		case "version":
+			importer.version = i
+		default:
+			if column.Set != nil {
+				importer.columns[i] = column
+			}
+		}
+	}
+
+	for _, required := range []string{"firstname", "lastname"} {
+		if !seen[required] && importer.id < 0 {
+			return nil, fmt.Errorf("the CSV file has no %s column", required)
+		}
+	}
+
+	return importer, nil
+}
+
+// next reads up to count rows, and returns no rows at the end of the file. A
+// row with an id updates the columns of the file of the person with that id, at
+// the version if one is given, and a row without one creates a person. A row
+// that cannot be parsed is returned with its error, and the rows after it are
+// still read.
+func (im *csvImporter) next(count int) ([]csvRow, error) {
+	var rows []csvRow
+	for len(rows) < count {
+		record, err := im.reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, csvRow{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", ErrValidation, parseErr.Err)})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := im.reader.FieldPos(0)
+		rows = append(rows, im.parse(line, record))
+	}
+
+	return rows, nil
+}
+
+// parse returns the row of the record at the line.
+func (im *csvImporter) parse(line int, record []string) csvRow {
+	row := csvRow{Line: line}
+	if im.id >= 0 && strings.TrimSpace(record[im.id]) != "" {
+		row.Op = BatchOperation{Op: BatchUpdate, ID: strings.TrimSpace(record[im.id])}
+		if im.version >= 0 && strings.TrimSpace(record[im.version]) != "" {
+			version, err := strconv.ParseInt(strings.TrimSpace(record[im.version]), 10, 64)
+			if err != nil || version < 1 {
+				row.Err = &ValidationError{Violations: []Violation{{Name: "version", Reason: "must be a positive number"}}}
+				return row
+			}
+
+			row.Op.Version = version
+		}
+
+		row.Patch, row.Err = im.mergePatch(record)
+		return row
+	}
+
+	row.Op = BatchOperation{Op: BatchCreate, Person: &Person{}}
+	for i, value := range record {
+		if column := im.columns[i]; column != nil {
+			column.Set(row.Op.Person, unescapeCSVFormula(strings.TrimSpace(value)))
+		}
+	}
+
+	row.Err = row.Op.Validate()
+	return row
+}
+
+// mergePatch returns the merge patch that sets the fields of the columns of the
+// file to the values of the record, and removes those with an empty value, so
+// that an update leaves the fields without a column alone.
+func (im *csvImporter) mergePatch(record []string) (MergePatch, error) {
+	patch := make(map[string]interface{})
+	for i, value := range record {
+		column := im.columns[i]
+		if column == nil {
+			continue
+		}
+
+		var member interface{}
+		if value = unescapeCSVFormula(strings.TrimSpace(value)); value != "" {
+			member = value
+		}
+
+		// The fields of the location and employment are merged into theirs.
+		object := patch
+		path := strings.Split(column.Name, ".")
+		for _, name := range path[:len(path)-1] {
+			nested, ok := object[name].(map[string]interface{})
+			if !ok {
+				nested = make(map[string]interface{})
+				object[name] = nested
+			}
+
+			object = nested
+		}
+
+		object[path[len(path)-1]] = member
+	}
+
+	data, err := json.Marshal(patch)
+	return MergePatch(data), err
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestEscapeCSVFormula tests that values a spreadsheet would run are escaped,
+// and that unescaping restores every value.
+func TestEscapeCSVFormula(t *testing.T) {
+	tests := []struct {
+		Value   string
+		Escaped string
+	}{
+		{"Smith", "Smith"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+44", "'+44"},
+		{"+442071234567", "+442071234567"},
+		{"+442071234567+1", "'+442071234567+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"O'Brien", "O'Brien"},
+		{"'quoted", "'quoted"},
+		{"'=already", "''=already"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Value, func(t *testing.T) {
+			assert.Equal(t, tc.Escaped, escapeCSVFormula(tc.Value))
+			assert.Equal(t, tc.Value, unescapeCSVFormula(escapeCSVFormula(tc.Value)))
+		})
+	}
+}
+
+// TestNewCSVImporter tests that the header row is mapped to the columns.
+func TestNewCSVImporter(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header string
+		Valid  bool
+	}{
+		{"names", "firstname,lastname", true},
+		{"export", "id,version,firstname,lastname,location.city,location.country,employment.status,deleted_at", true},
+		{"employment_aliases", "firstname,lastname,job_title,Department,start_date", true},
+		{"aliases", "Lastname, FirstName ,City,COUNTRY", true},
+		{"byte_order_mark", "\ufefffirstname,lastname", true},
+		{"empty", "", false},
+		{"unknown_column", "firstname,lastname,nickname", false},
+		{"duplicate_column", "firstname,lastname,city,location.city", false},
+		{"duplicate_employment_column", "firstname,lastname,status,employment.status", false},
+		{"update_columns", "id,version,email", true},
+		{"missing_lastname", "firstname,city", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := newCSVImporter(strings.NewReader(tc.Header + "\n"))
+			if tc.Valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// TestExportPeople tests the GET /person/export route.
+func TestExportPeople(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{
+		{
+			Firstname:      "John",
+			Lastname:       "Smith",
+			Location:       &Location{City: "London", Country: "GB"},
+			EmployeeNumber: "E00001",
+			Email:          "john.smith@example.com",
+			Phone:          "+442071234567",
+			Employment:     &Employment{JobTitle: "Manager", Department: "Sales", StartDate: "2020-01-06", Status: EmploymentActive, SalaryBand: "B6"},
+		},
+		{Firstname: "=cmd", Lastname: "Jones", Location: &Location{City: "Leeds", Country: "GB"}},
+		{Firstname: "Marie", Lastname: "Dupont", Location: &Location{City: "Paris", Country: "FR"}},
+	})
+
+	rec := serve(store, http.MethodGet, "/person/export?format=csv&country=GB&sort=-lastname", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "people.csv")
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	header := "id,version,firstname,lastname,location.city,location.country,employee_number,email,phone," +
+		"employment.job_title,employment.department,employment.manager_id,employment.start_date," +
+		"employment.end_date,employment.status,employment.salary_band,deleted_at"
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, strings.Split(header, ","), records[0])
+		assert.Equal(t, []string{"1", "John", "Smith", "London", "GB", "E00001", "john.smith@example.com", "+442071234567",
+			"Manager", "Sales", "", "2020-01-06", "", "active", "B6", ""}, records[1][1:])
+		assert.Equal(t, []string{"1", "'=cmd", "Jones", "Leeds", "GB", "", "", "", "", "", "", "", "", "", "", ""}, records[2][1:])
+	}
+
+	rec = serve(store, http.MethodGet, "/person/export?country=DE", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, header+"\n", rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve(store, http.MethodGet, "/person/export?format=xlsx", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(store, http.MethodGet, "/person/export?include_deleted=maybe", "").Code)
+}
+
+// importCSV posts the CSV file to the target and decodes the report.
+func importCSV(t *testing.T, store PersonStore, target, body string) importReport {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var report importReport
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	return report
+}
+
+// TestImportPeople tests the POST /person/import route.
+func TestImportPeople(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith"}})
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	john := people[0].ID.Hex()
+
+	file := "firstname,lastname,city,country,id,version\n" +
+		"Emma,Jones,Leeds,GB,,\n" +
+		"Marie,Dupont,,,,\n" +
+		"Jane,,Paris,FR,,\n" +
+		"\"Multi\nline\",Doe,'-,,,\n" +
+		"Jack,Smith,London,GB," + john + ",1\n" +
+		"Jack,Smith,London,GB," + john + ",1\n" +
+		"Jack,Smith,London,GB," + john + ",first\n" +
+		"Bob,Brown,,,650000000000000000000000,\n" +
+		"too,few\n"
+
+	report := importCSV(t, store, "/person/import?dry_run=true", file)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 9, report.Rows)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 2, report.Updated)
+	assert.Equal(t, 4, report.Failed)
+	if assert.Len(t, report.Errors, 4) {
+		assert.Equal(t, http.StatusNotFound, report.Errors[2].Status)
+	}
+
+	count, err := store.CountPeople(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	report = importCSV(t, store, "/person/import", file)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 9, report.Rows)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 5, report.Failed)
+
+	lines := make([]int, len(report.Errors))
+	statuses := make([]int, len(report.Errors))
+	for i, rowErr := range report.Errors {
+		lines[i] = rowErr.Line
+		statuses[i] = rowErr.Status
+	}
+
+	assert.Equal(t, []int{4, 8, 9, 10, 11}, lines)
+	assert.Equal(t, []int{422, 412, 422, 404, 422}, statuses)
+	assert.Equal(t, "lastname", report.Errors[0].InvalidParams[0].Name)
+	assert.Equal(t, john, report.Errors[1].ID)
+
+	person, err := store.GetPersonByObjectId(context.Background(), john)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jack", person.Firstname)
+	assert.Equal(t, "London", person.Location.City)
+
+	people, err = store.GetAllPeople(context.Background(), bson.M{"lastname": "Doe"}, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, people, 1) {
+		assert.Equal(t, "Multi\nline", people[0].Firstname)
+		assert.Equal(t, "-", people[0].Location.City)
+	}
+
+	people, err = store.GetAllPeople(context.Background(), bson.M{"lastname": "Dupont"}, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, people, 1) {
+		assert.Nil(t, people[0].Location)
+	}
+}
+
+// TestImportPeopleEmployment tests that the employment columns are imported and validated.
+func TestImportPeopleEmployment(t *testing.T) {
+	store := NewMemoryStore()
+	file := "firstname,lastname,employee_number,email,phone,job_title,department,start_date,end_date,status\n" +
+		"Emma,Jones,E00001,emma.jones@example.com,'+442071234567,Analyst,Finance,2021-04-01,,active\n" +
+		"Marie,Dupont,,,,,,,,\n" +
+		"Bob,Brown,E00003,bob,+44,Analyst,Finance,2021-04-01,2020-12-31,retired\n"
+
+	report := importCSV(t, store, "/person/import", file)
+	assert.Equal(t, 2, report.Created)
+	if assert.Len(t, report.Errors, 1) {
+		names := make([]string, len(report.Errors[0].InvalidParams))
+		for i, violation := range report.Errors[0].InvalidParams {
+			names[i] = violation.Name
+		}
+
+		assert.Equal(t, []string{"email", "phone", "employment.status", "employment.end_date"}, names)
+	}
+
+	people, err := store.GetAllPeople(context.Background(), bson.M{"employee_number": "E00001"}, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, people, 1) {
+		assert.Equal(t, "+442071234567", people[0].Phone)
+		assert.Equal(t, &Employment{JobTitle: "Analyst", Department: "Finance", StartDate: "2021-04-01", Status: EmploymentActive}, people[0].Employment)
+	}
+
+	people, err = store.GetAllPeople(context.Background(), bson.M{"lastname": "Dupont"}, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, people, 1) {
+		assert.Nil(t, people[0].Employment)
+	}
+}
+
+// TestImportPeoplePartialUpdate tests that an update only changes the fields
+// with a column in the file, and removes those with an empty value.
+func TestImportPeoplePartialUpdate(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{
+		Firstname:  "John",
+		Lastname:   "Smith",
+		Email:      "john.smith@example.com",
+		Location:   &Location{City: "London", Country: "GB"},
+		Employment: &Employment{JobTitle: "Analyst", Department: "Finance", SalaryBand: "B6"},
+	}})
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	john := people[0].ID.Hex()
+
+	report := importCSV(t, store, "/person/import", "id,city,department,email\n"+john+",Leeds,Sales,\n")
+	assert.Equal(t, 1, report.Updated)
+	assert.Empty(t, report.Errors)
+
+	person, err := store.GetPersonByObjectId(context.Background(), john)
+	assert.NoError(t, err)
+	assert.Equal(t, "John", person.Firstname)
+	assert.Equal(t, "Smith", person.Lastname)
+	assert.Empty(t, person.Email)
+	assert.Equal(t, &Location{City: "Leeds", Country: "GB"}, person.Location)
+	assert.Equal(t, &Employment{JobTitle: "Analyst", Department: "Sales", SalaryBand: "B6"}, person.Employment)
+
+	// A required field cannot be emptied.
+	report = importCSV(t, store, "/person/import", "id,lastname\n"+john+",\n")
+	if assert.Len(t, report.Errors, 1) {
+		assert.Equal(t, http.StatusUnprocessableEntity, report.Errors[0].Status)
+	}
+}
+
+// TestImportPeopleRoundTrip tests that an exported file can be edited and imported again.
+func TestImportPeopleRoundTrip(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}})
+
+	exported := serve(store, http.MethodGet, "/person/export", "").Body.String()
+	edited := strings.Replace(exported, "London", "Leeds", 1)
+
+	report := importCSV(t, store, "/person/import", edited)
+	assert.Equal(t, 1, report.Updated)
+	assert.Empty(t, report.Errors)
+
+	// The export now holds an outdated version, so importing it again fails,
+	// as does a dry run of it.
+	for _, target := range []string{"/person/import?dry_run=true", "/person/import"} {
+		report = importCSV(t, store, target, exported)
+		assert.Equal(t, 1, report.Failed)
+		if assert.Len(t, report.Errors, 1) {
+			assert.Equal(t, http.StatusPreconditionFailed, report.Errors[0].Status)
+		}
+	}
+
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Leeds", people[0].Location.City)
+}
+
+// failingReader returns the data, and then fails.
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, r.err
+	}
+
+	return n, err
+}
+
+// TestImportPeopleReadError tests that a file that fails partway is answered
+// with a problem reporting the batches applied before.
+func TestImportPeopleReadError(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Err    error
+		Status int
+	}{
+		{"reset", errors.New("connection reset"), http.StatusBadRequest},
+		{"timeout", fmt.Errorf("read tcp: %w", os.ErrDeadlineExceeded), http.StatusRequestTimeout},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := NewMemoryStore()
+			cfg := DefaultConfig()
+			cfg.BatchLimit = 2
+			body := &failingReader{data: strings.NewReader("firstname,lastname\nEmma,Jones\nMarie,Dupont\nJack,Brown\n"), err: tc.Err}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/person/import", body)
+			req.Header.Set("Content-Type", "text/csv")
+			NewRouter(store, cfg, nil).ServeHTTP(rec, req)
+			assert.Equal(t, tc.Status, rec.Code)
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+			var problem importProblem
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+			assert.Contains(t, problem.Detail, "after 2 rows")
+			if assert.NotNil(t, problem.Report) {
+				assert.Equal(t, 2, problem.Report.Rows)
+				assert.Equal(t, 2, problem.Report.Created)
+			}
+
+			count, err := store.CountPeople(context.Background(), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), count)
+		})
+	}
+}
+
+// TestImportPeopleRequest tests that files that cannot be imported are rejected as a whole.
+func TestImportPeopleRequest(t *testing.T) {
+	store := newFakeStore()
+	tests := []struct {
+		Name        string
+		ContentType string
+		Target      string
+		Body        string
+		Status      int
+	}{
+		{"valid", "text/csv; charset=utf-8", "/person/import", "firstname,lastname\nEmma,Jones\n", http.StatusOK},
+		{"no_content_type", "", "/person/import", "firstname,lastname\nEmma,Jones\n", http.StatusOK},
+		{"json", "application/json", "/person/import", `[{"firstname":"Emma"}]`, http.StatusUnsupportedMediaType},
+		{"empty", "text/csv", "/person/import", "", http.StatusBadRequest},
+		{"unknown_column", "text/csv", "/person/import", "firstname,lastname,age\n", http.StatusBadRequest},
+		{"invalid_dry_run", "text/csv", "/person/import?dry_run=perhaps", "firstname,lastname\n", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tc.Target, strings.NewReader(tc.Body))
+			req.Header.Set("Content-Type", tc.ContentType)
+			NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+			assert.Equal(t, tc.Status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+// TestImportPeopleDryRunAuth tests that a dry run checks the role of the caller
+// as the import would, although nothing is written.
+func TestImportPeopleDryRunAuth(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith"}})
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	john := people[0].ID.Hex()
+	router := NewRouter(store, DefaultConfig(), newTestAuthenticator(t))
+
+	tests := []struct {
+		Name    string
+		Key     string
+		Body    string
+		Status  int
+		Created int
+		Failed  int
+		// Import is whether the file is then imported for real, expecting the
+		// same answer as the dry run.
+		Import bool
+	}{
+		{"editor_create", testAPIKey, "firstname,lastname\nEmma,Jones\n", http.StatusOK, 1, 0, false},
+		{"viewer_create", testViewerAPIKey, "firstname,lastname\nEmma,Jones\n", http.StatusForbidden, 0, 0, true},
+		{"viewer_update", testViewerAPIKey, "id,firstname\n" + john + ",Jack\n", http.StatusOK, 0, 1, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			for _, dryRun := range []bool{true, !tc.Import} {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/person/import?dry_run=%t", dryRun), strings.NewReader(tc.Body))
+				req.Header.Set("Content-Type", "text/csv")
+				req.Header.Set(APIKeyHeader, tc.Key)
+				router.ServeHTTP(rec, req)
+				assert.Equal(t, tc.Status, rec.Code, rec.Body.String())
+				if rec.Code != http.StatusOK {
+					var problem importProblem
+					assert.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+					assert.Equal(t, 0, problem.Report.Created)
+					continue
+				}
+
+				var report importReport
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+				assert.Equal(t, tc.Created, report.Created)
+				assert.Equal(t, tc.Failed, report.Failed)
+				if tc.Failed > 0 {
+					assert.Equal(t, http.StatusForbidden, report.Errors[0].Status)
+				}
+			}
+		})
+	}
+
+	count, err := store.CountPeople(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestExportPeopleError tests that an export failing midway is ended with
+// error trailers after the rows read, and that one failing before the first
+// person is a problem.
+func TestExportPeopleError(t *testing.T) {
+	rec := serve(&failingStreamStore{fakeStore: newFakeStore(), count: 2}, http.MethodGet, "/person/export", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "500", rec.Result().Trailer.Get(streamStatusTrailer))
+	assert.Equal(t, "cursor failed: connection reset", rec.Result().Trailer.Get(streamErrorTrailer))
+
+	rec = serve(&failingStreamStore{fakeStore: newFakeStore()}, http.MethodGet, "/person/export", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Result().Trailer)
+}
@@ -3,246 +3,108 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"net/url"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var client *mongo.Client
-var err error
-var isInMemory bool
-var peopleCollection *mongo.Collection
-var personMap map[string]Person
-
-// ConnectDatabase establishes a connection to the database and initializes it.
-// If the application is running in memory mode, it seeds the database with mock data.
-// If the application is using MongoDB, it checks if the collection is empty and seeds the database if necessary.
-func ConnectDatabase() {
-	dbPath := "mongodb://localhost:27017"
-	connectToMongoDB(dbPath)
-
-	if isInMemory {
-		err = seedDatabase()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		return
-	}
-
-	isDatabaseConnected()
-	if isEmpty, err := isCollectionEmpty(); err != nil {
-		log.Fatal(err)
-	} else if isEmpty {
-		fmt.Println("Found no documents in collection, seeding the database...")
-		err = seedDatabase()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-}
-
-// CreatePersonRecord creates a new person record in the database.
-// If the application is in memory mode, it adds the person to the in-memory map.
-// If the application is using MongoDB, it inserts the person into the database.
-func CreatePersonRecord(person Person) (*Person, error) {
-	if isInMemory {
-		objectId := primitive.NewObjectID()
-		personMap[objectId.String()] = person
-		return &person, nil
+// ConnectDatabase establishes a connection to the database and returns the store to use.
+// With StorageAuto, if no MongoDB is available it returns a MemoryStore seeded with mock data.
+// If MongoDB is used, it checks if the collection is empty and seeds the database if necessary.
+func ConnectDatabase(ctx context.Context, cfg *Config) PersonStore {
+	if cfg.Storage == StorageMemory {
+		return newSeededMemoryStore(cfg)
 	}
 
-	_, err := peopleCollection.InsertOne(context.TODO(), person)
+	client, err := connectToMongoDB(ctx, cfg)
 	if err != nil {
-		return &Person{}, err
-	}
-
-	return &person, nil
-}
-
-// DeletePersonRecord deletes a person record from the database by its ObjectID.
-// If the application is in memory mode, it deletes the person from the in-memory map.
-// If the application is using MongoDB, it deletes the person record from the database.
-func DeletePersonRecord(id string) (*mongo.DeleteResult, error) {
-	if isInMemory {
-		if _, ok := personMap[id]; ok {
-			delete(personMap, id)
-			return &mongo.DeleteResult{DeletedCount: 1}, nil
-		} else {
-			return nil, fmt.Errorf("no person with the given id was found")
+		if cfg.Storage == StorageMongo {
+			fatal("cannot connect to MongoDB", err)
 		}
-	}
 
-	objectId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
+		slog.Warn("no MongoDB available, reverting to in-memory storage", "error", err)
+		return newSeededMemoryStore(cfg)
 	}
 
-	filter := bson.M{"_id": objectId}
-	result, err := peopleCollection.DeleteOne(context.TODO(), filter)
-	if err != nil {
-		return nil, err
+	database := client.Database(cfg.Database)
+	store := NewMongoStore(database.Collection(cfg.Collection), database.Collection(cfg.AuditCollection))
+	if err = store.ensureIndexes(ctx); err != nil {
+		slog.Warn("cannot create the audit trail index", "error", err)
 	}
 
-	return result, nil
-}
-
-// GetAllPeople retrieves all person records from the database that match the provided query.
-// If the application is in memory mode, it retrieves all person records from the in-memory map.
-// If the application is using MongoDB, it queries the database for matching records.
-func GetAllPeople(query bson.M) ([]*Person, error) {
-
-	if isInMemory {
-		//	TODO: Handle query on personMap.
-		return ConvertToSlice(personMap), nil
+	if migrated, err := store.migrateVersions(ctx); err != nil {
+		fatal("cannot set the version of existing people", err)
+	} else if migrated > 0 {
+		slog.Info("set the version of existing people", "count", migrated)
 	}
 
-	var result []*Person
-
-	// Returning multiple docs returns a 'cursor' object, decode one by one.
-	cursor, err := peopleCollection.Find(context.TODO(), query, nil)
-	if err != nil {
-		return nil, err
+	if migrated, err := store.migrateCountries(ctx); err != nil {
+		fatal("cannot migrate the country names of existing people", err)
+	} else if migrated > 0 {
+		slog.Info("migrated the country names of existing people", "count", migrated)
 	}
 
-	defer cursor.Close(context.TODO())
-
-	for cursor.Next(context.TODO()) {
-		var person Person
-		err = cursor.Decode(&person)
-		if err != nil {
-			return nil, err
+	if isEmpty, err := store.isEmpty(ctx); err != nil {
+		fatal("cannot count documents", err)
+	} else if isEmpty && cfg.SeedCount > 0 {
+		slog.Info("found no documents in collection, seeding the database", "count", cfg.SeedCount)
+		if err = store.seed(ctx, generatePeople(cfg.SeedCount)); err != nil {
+			fatal("cannot seed the database", err)
 		}
-
-		result = append(result, &person)
 	}
 
-	if err = cursor.Err(); err != nil {
-		return nil, err
-	}
-
-	return result, nil
+	return store
 }
 
-// GetPersonByObjectId retrieves a person record from the database by its ObjectID.
-// If the application is in memory mode, it retrieves the person from the in-memory map.
-// If the application is using MongoDB, it queries the database for the person record.
-func GetPersonByObjectId(id string) (*Person, error) {
-	if isInMemory {
-		person, ok := personMap[id]
-		if ok {
-			return person.Clone(), nil
-		} else {
-			return nil, fmt.Errorf("person not found")
-		}
-	} else {
-		var person *Person
-		docId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return nil, err
+// newSeededMemoryStore returns a MemoryStore loaded from the snapshot file, if
+// one is configured, and seeds it with mock data if it is empty.
+func newSeededMemoryStore(cfg *Config) *MemoryStore {
+	store := NewMemoryStore()
+	if cfg.MemorySnapshot != "" {
+		var err error
+		if store, err = OpenMemoryStore(cfg.MemorySnapshot); err != nil {
+			fatal("cannot open the memory snapshot", err)
 		}
-
-		filter := bson.D{{Key: "_id", Value: docId}}
-		err = peopleCollection.FindOne(context.TODO(), filter).Decode(&person)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				return nil, fmt.Errorf("person not found")
-			}
-
-			return nil, err
-		}
-
-		return person, nil
 	}
-}
-
-// PatchPersonRecord updates a person's record either in-memory or in MongoDB,
-// depending on the configuration.
-func PatchPersonRecord(patch Patch, id string) (*Person, error) {
-	if isInMemory {
-		person, ok := personMap[id]
-		if !ok {
-			return nil, fmt.Errorf("person not found")
-		}
-
-		err = SetFieldByReflection(&person, patch.Path, patch.Value)
-		if err != nil {
-			return nil, err
-		}
 
-		personMap[id] = person
-		return &person, nil
-	} else {
-		replace := bson.M{patch.Path: patch.Value}
-		objectId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return nil, err
+	if store.isEmpty() {
+		if err := store.seed(generatePeople(cfg.SeedCount)); err != nil {
+			fatal("cannot seed the in-memory store", err)
 		}
-
-		filter := bson.M{"_id": objectId}
-		update := bson.M{"$set": replace}
-		_, err = peopleCollection.UpdateOne(context.TODO(), filter, update)
-		if err != nil {
-			return nil, err
-		}
-
-		return GetPersonByObjectId(id)
 	}
-}
-
-// UpdatePersonRecord updates an existing person record in the database.
-// If the application is in memory mode, it updates the person in the in-memory map.
-// If the application is using MongoDB, it updates the person in the database.
-func UpdatePersonRecord(person Person, id string) (*Person, error) {
-	if isInMemory {
-		_, ok := personMap[id]
-		if !ok {
-			return &Person{}, fmt.Errorf("person not found")
-		}
-
-		_, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return &Person{}, err
-		}
-
-		personMap[id] = person
-		return &person, nil
-	} else {
-		objectId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return &Person{}, err
-		}
 
-		filter := bson.M{"_id": objectId}
-		update := bson.M{"$set": person}
+	return store
+}
 
-		_, err = peopleCollection.UpdateOne(context.TODO(), filter, update)
-		if err != nil {
-			return &Person{}, err
-		}
+func connectToMongoDB(ctx context.Context, cfg *Config) (*mongo.Client, error) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.MongoURI).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetServerSelectionTimeout(cfg.ConnectTimeout)
 
-		return GetPersonByObjectId(id)
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func connectToMongoDB(path string) {
-	client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(path))
-	if err != nil {
-		log.Println("No mongo database available. Reverting to in-memory storage")
-		isInMemory = true
-		return
+	// The client is disconnected when the store is closed, or here if MongoDB
+	// cannot be reached.
+	if err = isDatabaseConnected(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
 	}
 
-	defer client.Disconnect(context.TODO())
-	fmt.Printf("Connected to %v!\n", path)
+	slog.Info("connected to MongoDB", "uri", redactURI(cfg.MongoURI))
+	return client, nil
 }
 
-func generatePeople() People {
+func generatePeople(count int) People {
 	source := rand.NewSource(time.Now().UnixNano())
 	r := rand.New(source)
 
@@ -258,58 +120,76 @@ func generatePeople() People {
 		"Madrid", "Rome", "Moscow", "Beijing", "Dubai", "Singapore", "Hong Kong", "Mumbai", "Rio de Janeiro",
 		"Cape Town", "Bangkok", "Seoul", "Amsterdam", "Stockholm", "Oslo", "Helsinki", "Vienna"}
 
-	countries := [25]string{"UK", "France", "USA", "Japan", "Germany", "Australia", "Canada", "Spain", "Italy", "Russia",
-		"China", "UAE", "Singapore", "Hong Kong", "India", "Brazil", "South Africa", "Thailand", "South Korea", "Netherlands",
-		"Sweden", "Norway", "Finland", "Austria"}
+	countries := [25]string{"GB", "FR", "US", "JP", "DE", "AU", "CA", "ES", "IT", "RU",
+		"CN", "AE", "SG", "HK", "IN", "BR", "ZA", "TH", "KR", "NL",
+		"SE", "NO", "FI", "AT", "IE"}
+
+	departments := [6]string{"Engineering", "Finance", "Human Resources", "Marketing", "Operations", "Sales"}
+
+	jobTitles := [6]string{"Analyst", "Associate", "Specialist", "Senior Specialist", "Team Lead", "Manager"}
+
+	salaryBands := [6]string{"B1", "B2", "B3", "B4", "B5", "B6"}
+
+	// The first tenth of the people manage the others, and have no manager
+	// themselves if they are the first.
+	managers := count/10 + 1
+	today := time.Now()
 
 	var users []Person
-	for i := 0; i < 100; i++ {
+	for i := 0; i < count; i++ {
 		firstname := firstnames[r.Intn(len(firstnames))]
 		lastname := lastnames[r.Intn(len(lastnames))]
 		location := &Location{
 			City:    cities[r.Intn(len(cities))],
 			Country: countries[r.Intn(len(countries))],
 		}
+
+		level := r.Intn(len(jobTitles))
+		start := today.AddDate(0, 0, -r.Intn(10*365))
+		employment := &Employment{
+			JobTitle:   jobTitles[level],
+			Department: departments[r.Intn(len(departments))],
+			StartDate:  start.Format(dateLayout),
+			Status:     EmploymentActive,
+			SalaryBand: salaryBands[level],
+		}
+
+		if i > 0 {
+			employment.ManagerID = users[r.Intn(min(i, managers))].ID.Hex()
+		}
+
+		switch n := r.Intn(20); {
+		case n == 0:
+			employment.Status = EmploymentOnLeave
+		case n < 3:
+			employment.Status = EmploymentTerminated
+			employment.EndDate = start.AddDate(0, 0, r.Intn(int(today.Sub(start).Hours()/24)+1)).Format(dateLayout)
+		}
+
 		users = append(users, Person{
-			Firstname: firstname,
-			Lastname:  lastname,
-			Location:  location,
+			ID:             primitive.NewObjectID(),
+			Firstname:      firstname,
+			Lastname:       lastname,
+			Location:       location,
+			EmployeeNumber: fmt.Sprintf("E%05d", i+1),
+			Email:          strings.ToLower(fmt.Sprintf("%s.%s.%d@example.com", firstname, lastname, i+1)),
+			Phone:          fmt.Sprintf("+447%09d", r.Intn(1e9)),
+			Employment:     employment,
 		})
 	}
 	return users
 }
 
-func isCollectionEmpty() (bool, error) {
-	var count int64
-	count, err = peopleCollection.CountDocuments(context.TODO(), bson.M{})
+// redactURI replaces the password in a connection URI with "xxxxx", so that it can be logged.
+func redactURI(uri string) string {
+	parsed, err := url.Parse(uri)
 	if err != nil {
-		return false, fmt.Errorf("error counting documents in collection: %v", err)
+		return "(invalid uri)"
 	}
 
-	return count == 0, nil
+	return parsed.Redacted()
 }
 
-func isDatabaseConnected() {
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func seedDatabase() error {
-	if isInMemory {
-		for _, person := range generatePeople() {
-			objectId := primitive.NewObjectID()
-			personMap[objectId.String()] = person
-		}
-
-		return nil
-	} else {
-		_, err := peopleCollection.InsertMany(context.TODO(), generatePeople().ConvertToInterface())
-		if err != nil {
-			return err
-		}
-
-		return nil
-	}
+func isDatabaseConnected(ctx context.Context, client *mongo.Client) error {
+	return client.Ping(ctx, nil)
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The errors returned by a PersonStore and the patch and validation functions.
+// Use errors.Is to test for them, as they are usually wrapped with more detail.
+// writeError maps each of them to an HTTP status code.
+var (
+	// ErrNotFound is returned when no person has the given id.
+	ErrNotFound = errors.New("person not found")
+
+	// ErrInvalidID is returned when an id is not a hex ObjectID.
+	ErrInvalidID = errors.New("invalid person id")
+
+	// ErrConflict is returned when a change conflicts with the current state of a person.
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is returned when a person fails validation.
+	ErrValidation = errors.New("validation failed")
+
+	// ErrPreconditionFailed is returned when a person is not at the version a change requires.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrForbidden is returned when the roles of the caller do not allow an operation.
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrBatchAborted is returned for the operations of an all-or-nothing batch
+	// that were not applied, or were rolled back, as another operation failed.
+	ErrBatchAborted = errors.New("not applied, as another operation of the batch failed")
+)
+
+// parseObjectID parses a hex person id, returning an error wrapping ErrInvalidID if it is not valid.
+func parseObjectID(id string) (primitive.ObjectID, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	return objectId, nil
+}
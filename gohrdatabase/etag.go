@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ETag returns the strong entity tag of a person at the version, e.g. "3".
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag sets the ETag header of the response to the version of the person.
+func setETag(w http.ResponseWriter, person *Person) {
+	w.Header().Set("ETag", ETag(person.Version))
+}
+
+// parseIfMatch returns the versions in the If-Match header of the request, one
+// of which the person must be at, or none if it has none or it is "*". Entity
+// tags that are not the tag of any version never match, and a header without
+// any other fails with ErrPreconditionFailed.
+func parseIfMatch(req *http.Request) ([]int64, error) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match, as If-Match requires a strong comparison.
+		tag = strings.TrimSpace(tag)
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err == nil && ETag(version) == tag && version >= 1 {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s is not the entity tag of a version of the person", ErrPreconditionFailed, header)
+	}
+
+	return versions, nil
+}
+
+// matchesIfNoneMatch reports whether the If-None-Match header of the request
+// matches the entity tag, using the weak comparison it requires.
+func matchesIfNoneMatch(req *http.Request, etag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfVersion returns a patch that is only applied to a person at one of the
+// given versions, and fails with an error wrapping ErrPreconditionFailed
+// otherwise.
+func IfVersion(patch PersonPatch, versions ...int64) PersonPatch {
+	return versionedPatch{patch: patch, versions: versions}
+}
+
+type versionedPatch struct {
+	patch    PersonPatch
+	versions []int64
+}
+
+func (p versionedPatch) Apply(person Person) (*Person, error) {
+	if err := checkVersion(person, p.versions...); err != nil {
+		return nil, err
+	}
+
+	return p.patch.Apply(person)
+}
+
+// checkVersion returns an error wrapping ErrPreconditionFailed if the person is
+// at none of the versions. Any version matches when none is given.
+func checkVersion(person Person, versions ...int64) error {
+	if len(versions) == 0 || slices.Contains(versions, person.Version) {
+		return nil
+	}
+
+	required := make([]string, len(versions))
+	for i, version := range versions {
+		required[i] = strconv.FormatInt(version, 10)
+	}
+
+	return fmt.Errorf("%w: the person is at version %d, not %s", ErrPreconditionFailed, person.Version, strings.Join(required, " or "))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseIfMatch tests that the strong entity tags of versions are accepted,
+// alone or in a list.
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Header   string
+		Versions []int64
+		Err      error
+	}{
+		{"none", "", nil, nil},
+		{"any", "*", nil, nil},
+		{"version", `"3"`, []int64{3}, nil},
+		{"list", `"1", "2"`, []int64{1, 2}, nil},
+		{"list_without_spaces", `"1","2"`, []int64{1, 2}, nil},
+		{"list_with_weak", `W/"1", "2"`, []int64{2}, nil},
+		{"weak", `W/"3"`, nil, ErrPreconditionFailed},
+		{"unquoted", `3`, nil, ErrPreconditionFailed},
+		{"not_a_version", `"abc"`, nil, ErrPreconditionFailed},
+		{"zero", `"0"`, nil, ErrPreconditionFailed},
+		{"list_without_versions", `W/"1", "abc"`, nil, ErrPreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/person/1", nil)
+			req.Header.Set("If-Match", tc.Header)
+
+			versions, err := parseIfMatch(req)
+			assert.Equal(t, tc.Versions, versions)
+			if tc.Err != nil {
+				assert.ErrorIs(t, err, tc.Err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestMatchesIfNoneMatch tests the weak comparison of If-None-Match.
+func TestMatchesIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		Header  string
+		Matches bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"2"`, true},
+		{`W/"2"`, true},
+		{`"1", "2"`, true},
+		{`"1"`, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/person/1", nil)
+			req.Header.Set("If-None-Match", tc.Header)
+			assert.Equal(t, tc.Matches, matchesIfNoneMatch(req, ETag(2)))
+		})
+	}
+}
+
+// TestOptimisticConcurrency tests that the ETag of a person changes with each
+// change to them, and that changes based on an outdated ETag are rejected.
+func TestOptimisticConcurrency(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith"}})
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	target := "/person/" + people[0].ID.Hex()
+
+	request := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		rec := httptest.NewRecorder()
+		NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+	rec = request(http.MethodGet, "", map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+	rec = request(http.MethodPut, `{"firstname":"John","lastname":"Smythe","version":7}`, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"version":2`)
+
+	// A second editor still holding version 1 cannot overwrite the change.
+	rec = request(http.MethodPut, `{"firstname":"Johnny","lastname":"Smith"}`, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	patch := `{"firstname":"Johnny"}`
+	mergePatch := map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": `"1"`}
+	assert.Equal(t, http.StatusPreconditionFailed, request(http.MethodPatch, patch, mergePatch).Code)
+
+	mergePatch["If-Match"] = `"2"`
+	rec = request(http.MethodPatch, patch, mergePatch)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	rec = request(http.MethodGet, "", map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Johnny")
+
+	rec = request(http.MethodPut, `{"firstname":"John","lastname":"Smith"}`, map[string]string{"If-Match": `"2", "3"`})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, request(http.MethodPut, `{"firstname":"John","lastname":"Smith"}`, map[string]string{"If-Match": `"2", "3"`}).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPut, `{"firstname":"John","lastname":"Smith"}`, map[string]string{"If-Match": "*"}).Code)
+
+	// A delete is only made to the version it names too.
+	assert.Equal(t, http.StatusPreconditionFailed, request(http.MethodDelete, "", map[string]string{"If-Match": `"4"`}).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "", map[string]string{"If-Match": `"4", "5"`}).Code)
+
+	events, err := store.GetPersonHistory(context.Background(), people[0].ID.Hex())
+	assert.NoError(t, err)
+	if assert.Len(t, events, 5) {
+		for i, event := range events {
+			assert.Equal(t, int64(i+2), event.Version)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matchesFilter reports whether the person matches the MongoDB filter document,
+// as produced by parseQuery. The operators parseQuery generates are supported,
+// which are $and, $or and $nor, and on fields $eq, $ne, $in, $nin, $regex and
+// $not, along with implicit equality, $exists as used by notDeleted, and $gt
+// and $lt as used by pagedQuery. Dotted paths such as "location.city" are
+// resolved against the person's BSON field names.
+func matchesFilter(person Person, filter bson.M) (bool, error) {
+	if len(filter) == 0 {
+		return true, nil
+	}
+
+	doc, err := toDocument(person)
+	if err != nil {
+		return false, err
+	}
+
+	return matchesDocument(doc, filter)
+}
+
+func matchesDocument(doc bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		var matched bool
+		var err error
+
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = matchesLogical(doc, key, condition)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator: %s", key)
+			}
+
+			value, found := lookupPath(doc, key)
+			matched, err = matchesCondition(value, found, condition)
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func matchesLogical(doc bson.M, op string, operand interface{}) (bool, error) {
+	filters := reflect.ValueOf(operand)
+	if filters.Kind() != reflect.Slice && filters.Kind() != reflect.Array {
+		return false, fmt.Errorf("%s requires an array, got %T", op, operand)
+	}
+
+	matches := 0
+	for i := 0; i < filters.Len(); i++ {
+		filter, ok := filters.Index(i).Interface().(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s requires an array of documents", op)
+		}
+
+		matched, err := matchesDocument(doc, filter)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			matches++
+		}
+	}
+
+	switch op {
+	case "$and":
+		return matches == filters.Len(), nil
+	case "$or":
+		return matches > 0, nil
+	}
+
+	return matches == 0, nil
+}
+
+func matchesCondition(value interface{}, found bool, condition interface{}) (bool, error) {
+	operators, ok := condition.(bson.M)
+	if !ok {
+		return found && reflect.DeepEqual(value, condition), nil
+	}
+
+	for op, operand := range operators {
+		var matched bool
+		var err error
+
+		switch op {
+		case "$eq":
+			matched = found && reflect.DeepEqual(value, operand)
+		case "$ne":
+			matched = !found || !reflect.DeepEqual(value, operand)
+		case "$in":
+			matched, err = matchesAny(value, found, operand)
+		case "$nin":
+			matched, err = matchesAny(value, found, operand)
+			matched = !matched
+		case "$regex":
+			matched, err = matchesRegex(value, found, operand, operators["$options"])
+		case "$options":
+			if _, ok := operators["$regex"]; !ok {
+				return false, fmt.Errorf("$options requires $regex")
+			}
+
+			matched = true
+		case "$not":
+			matched, err = matchesCondition(value, found, operand)
+			matched = !matched
+		case "$gt", "$lt":
+			matched, err = matchesRange(value, found, op, operand)
+		case "$exists":
+			exists, ok := operand.(bool)
+			if !ok {
+				return false, fmt.Errorf("$exists requires a boolean, got %T", operand)
+			}
+
+			matched = found == exists
+		default:
+			return false, fmt.Errorf("unsupported query operator: %s", op)
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func matchesAny(value interface{}, found bool, operand interface{}) (bool, error) {
+	candidates := reflect.ValueOf(operand)
+	if candidates.Kind() != reflect.Slice && candidates.Kind() != reflect.Array {
+		return false, fmt.Errorf("$in requires an array, got %T", operand)
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	for i := 0; i < candidates.Len(); i++ {
+		if reflect.DeepEqual(value, candidates.Index(i).Interface()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchesRange reports whether the value is after ($gt) or before ($lt) the
+// operand. As in MongoDB, only values of the same type compare, which are
+// strings and ObjectIDs for a Person.
+func matchesRange(value interface{}, found bool, op string, operand interface{}) (bool, error) {
+	if !found {
+		return false, nil
+	}
+
+	var c int
+	switch bound := operand.(type) {
+	case string:
+		text, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+
+		c = strings.Compare(text, bound)
+	case primitive.ObjectID:
+		id, ok := value.(primitive.ObjectID)
+		if !ok {
+			return false, nil
+		}
+
+		c = bytes.Compare(id[:], bound[:])
+	default:
+		return false, fmt.Errorf("%s requires a string or an ObjectID, got %T", op, operand)
+	}
+
+	if op == "$gt" {
+		return c > 0, nil
+	}
+
+	return c < 0, nil
+}
+
+func matchesRegex(value interface{}, found bool, pattern interface{}, options interface{}) (bool, error) {
+	expression, ok := pattern.(string)
+	if !ok {
+		return false, fmt.Errorf("$regex requires a string, got %T", pattern)
+	}
+
+	if options != nil {
+		flags, ok := options.(string)
+		if !ok || strings.Trim(flags, "i") != "" {
+			return false, fmt.Errorf("unsupported $options: %v", options)
+		}
+
+		if flags != "" {
+			expression = "(?i)" + expression
+		}
+	}
+
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return false, err
+	}
+
+	text, ok := value.(string)
+	return found && ok && re.MatchString(text), nil
+}
+
+// lookupPath resolves a dotted path such as "location.city" within a document.
+func lookupPath(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(bson.M)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// toDocument converts the person to a BSON document so that field names and
+// omitempty rules are the same as those stored in MongoDB.
+func toDocument(person Person) (bson.M, error) {
+	data, err := bson.Marshal(person)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// fromDocument converts a BSON document back to a Person.
+func fromDocument(doc bson.M) (*Person, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var person Person
+	if err = bson.Unmarshal(data, &person); err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
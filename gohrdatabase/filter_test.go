@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestMatchesFilter tests the function matchesFilter
+func TestMatchesFilter(t *testing.T) {
+	person := Person{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}
+	homeless := Person{Firstname: "Emma", Lastname: "Jones"}
+
+	tests := []struct {
+		Name   string
+		Person Person
+		Filter bson.M
+		Result bool
+	}{
+		{"empty_filter", person, bson.M{}, true},
+		{"in_matches", person, bson.M{"firstname": bson.M{"$in": []string{"Emma", "John"}}}, true},
+		{"in_does_not_match", person, bson.M{"firstname": bson.M{"$in": []string{"Emma"}}}, false},
+		{"dotted_path_matches", person, bson.M{"location.city": bson.M{"$in": []string{"London", "Paris"}}}, true},
+		{"dotted_path_does_not_match", person, bson.M{"location.city": bson.M{"$in": []string{"Paris"}}}, false},
+		{"missing_parent", homeless, bson.M{"location.city": bson.M{"$in": []string{"London"}}}, false},
+		{"all_conditions_must_match", person, bson.M{"firstname": bson.M{"$in": []string{"John"}}, "location.country": bson.M{"$in": []string{"FR"}}}, false},
+		{"implicit_equality", person, bson.M{"lastname": "Smith"}, true},
+		{"eq", person, bson.M{"location.country": bson.M{"$eq": "GB"}}, true},
+		{"ne", person, bson.M{"location.country": bson.M{"$ne": "GB"}}, false},
+		{"nin_matches_missing_field", homeless, bson.M{"location.city": bson.M{"$nin": []string{"London"}}}, true},
+		{"regex", person, bson.M{"lastname": bson.M{"$regex": "^Sm"}}, true},
+		{"regex_case_sensitive", person, bson.M{"lastname": bson.M{"$regex": "^sm"}}, false},
+		{"regex_case_insensitive", person, bson.M{"lastname": bson.M{"$regex": "^sm", "$options": "i"}}, true},
+		{"not_regex", person, bson.M{"lastname": bson.M{"$not": bson.M{"$regex": "^Sm"}}}, false},
+		{"or", person, bson.M{"$or": []bson.M{{"firstname": "Emma"}, {"location.city": "London"}}}, true},
+		{"and", person, bson.M{"$and": []bson.M{{"firstname": "John"}, {"location.city": "Paris"}}}, false},
+		{"nor", person, bson.M{"$nor": []bson.M{{"firstname": "Emma"}, {"location.city": "Paris"}}}, true},
+		{"exists", person, bson.M{"location.city": bson.M{"$exists": true}}, true},
+		{"not_exists", homeless, bson.M{"location.city": bson.M{"$exists": false}}, true},
+		{"gt", person, bson.M{"lastname": bson.M{"$gt": "Jones"}}, true},
+		{"gt_equal", person, bson.M{"lastname": bson.M{"$gt": "Smith"}}, false},
+		{"lt", person, bson.M{"lastname": bson.M{"$lt": "Taylor"}}, true},
+		{"lt_missing_field", homeless, bson.M{"location.city": bson.M{"$lt": "Paris"}}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := matchesFilter(tc.Person, tc.Filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, actual)
+		})
+	}
+}
+
+// TestMatchesFilterUnsupportedOperator tests that unknown operators are rejected.
+func TestMatchesFilterUnsupportedOperator(t *testing.T) {
+	_, err := matchesFilter(Person{Firstname: "John"}, bson.M{"firstname": bson.M{"$elemMatch": bson.M{}}})
+	assert.Error(t, err)
+}
@@ -1,161 +1,751 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
-	"net/url"
-	"strings"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// A PersonHandler serves the /person routes from a PersonStore.
+type PersonHandler struct {
+	store         PersonStore
+	timeout       time.Duration
+	streamTimeout time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	batchLimit    int
+}
+
+// NewPersonHandler returns a PersonHandler that reads and writes people using
+// store. Each request may spend at most the StoreTimeout of cfg in the store,
+// or the StreamTimeout when its response is streamed, and a batch may have at
+// most BatchLimit operations.
+func NewPersonHandler(store PersonStore, cfg *Config) *PersonHandler {
+	return &PersonHandler{
+		store:         store,
+		timeout:       cfg.StoreTimeout,
+		streamTimeout: cfg.StreamTimeout,
+		readTimeout:   cfg.ReadTimeout,
+		writeTimeout:  cfg.WriteTimeout,
+		batchLimit:    cfg.BatchLimit,
+	}
+}
+
+// checkPermission checks that the caller has the permission, if the store
+// enforces a policy, for the operations a dry run does not perform.
+func (h *PersonHandler) checkPermission(ctx context.Context, permission Permission) error {
+	if checker, ok := h.store.(permissionChecker); ok {
+		return checker.checkPermission(ctx, permission)
+	}
+
+	return nil
+}
+
+// storeContext returns the context for the store operations of the request,
+// which is cancelled when the client goes away or the store timeout passes.
+func (h *PersonHandler) storeContext(req *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(req.Context(), h.timeout)
+}
+
 // CreatePerson handles the HTTP POST request to create a new person record.
-// It decodes the JSON request body into a Person struct, creates the record
-// in the database, and returns the result as a JSON response.
-func CreatePerson(w http.ResponseWriter, req *http.Request) {
+// It decodes and validates the JSON request body into a Person struct, creates
+// the record in the database, and returns the result as a JSON response.
+func (h *PersonHandler) CreatePerson(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var person Person
-	if err := json.NewDecoder(req.Body).Decode(&person); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	person, ok := decodePerson(w, req)
+	if !ok {
 		return
 	}
 
-	result, err := CreatePersonRecord(person)
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	result, err := h.store.CreatePersonRecord(ctx, person)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
+	setETag(w, result)
 	json.NewEncoder(w).Encode(result)
 }
 
 // DeletePerson handles the HTTP DELETE request to delete a person record by ID.
-// It retrieves the person ID from the request parameters, deletes the record
-// from the database, and returns the result as a JSON response.
-func DeletePerson(w http.ResponseWriter, req *http.Request) {
+// It retrieves the person ID from the request parameters, marks the record
+// deleted in the database, and returns the result as a JSON response. The
+// record can be restored until it is purged. With an If-Match header the
+// record is only deleted if its ETag matches, and fails with 412 Precondition
+// Failed otherwise.
+func (h *PersonHandler) DeletePerson(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	versions, ok := ifMatchVersions(w, req)
+	if !ok {
+		return
+	}
+
 	params := mux.Vars(req)
 	id := params["id"]
 
-	result, err := DeletePersonRecord(id)
-	if err != nil {
-		if err.Error() == "person not found" {
-			http.Error(w, "Person not found", http.StatusNotFound)
-			return
-		}
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	result, err := h.store.DeletePersonRecord(ctx, id, versions...)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(result)
 }
 
+// RestorePerson handles the HTTP POST request to restore a deleted person record
+// by ID. It returns the restored record as a JSON response, or 409 Conflict if
+// the record is not deleted.
+func (h *PersonHandler) RestorePerson(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := mux.Vars(req)
+	id := params["id"]
+
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	person, err := h.store.RestorePersonRecord(ctx, id)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	setETag(w, person)
+	json.NewEncoder(w).Encode(person)
+}
+
 // GetPeople handles the HTTP GET request to retrieve multiple person records
 // based on query parameters.
-// It parses the query parameters, constructs MongoDB filter criteria, retrieves
+// It parses the query parameters, constructs MongoDB filter criteria (see parseQuery), retrieves
 // matching records from the database, and returns them as a JSON response.
-func GetPeople(w http.ResponseWriter, req *http.Request) {
+// Results are paged with limit and either offset or page_token, ordered with
+// sort (e.g. sort=lastname,-firstname) and limited to the given fields (e.g.
+// fields=firstname,location.city). The total number of matching records is
+// returned in the X-Total-Count header, along with a Link header to other
+// pages, which has the page_token of the next page unless offset is used.
+// Deleted records are left out unless include_deleted=true is given.
+// With an Accept header of application/x-ndjson the records are streamed
+// instead, see streamPeople.
+func (h *PersonHandler) GetPeople(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+
+	filter, err := parsePeopleFilter(req)
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	queryFilters := getPeopleQueryFilter()
-	filter := parseQuery(req.URL.Query(), queryFilters)
+	opts, err := parseFindOptions(req.URL.Query())
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if acceptsMediaType(req, ndjsonMediaType) {
+		if !req.URL.Query().Has("limit") {
+			opts.Limit = 0
+		}
 
-	people, err := GetAllPeople(filter)
+		h.streamPeople(w, req, filter, opts)
+		return
+	}
+
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	total, err := h.store.CountPeople(ctx, filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, req, err)
 		return
 	}
 
-	if len(people) == 0 {
-		http.Error(w, "No people found", http.StatusNotFound)
+	readOpts := opts.withSortFields()
+	people, err := h.store.GetAllPeople(ctx, filter, readOpts)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
+	// A full page may be followed by another, which starts after its last person.
+	var next string
+	if int64(len(people)) == opts.Limit && !req.URL.Query().Has("offset") {
+		if next, err = newPageToken(opts, people[len(people)-1]); err != nil {
+			writeError(w, req, err)
+			return
+		}
+	}
+
+	if readOpts != opts {
+		for i, person := range people {
+			if people[i], err = projectPerson(person, opts.Fields); err != nil {
+				writeError(w, req, err)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	w.Header().Set("Link", buildLinkHeader(req.URL, opts, total, next))
 	json.NewEncoder(w).Encode(people)
 }
 
+// streamPeople writes the people matching the filter to the response as
+// newline delimited JSON, one person a line, as they are read from the store,
+// so that every matching record can be read without holding them all in
+// memory. Every match is streamed unless a limit is given, and the stream may
+// take the stream timeout rather than the store timeout. As the status is sent
+// with the first person, the outcome of the stream is sent in the
+// X-Stream-Status and X-Stream-Error trailers, see peopleStream.finish. An
+// error before the first person is answered with a problem as usual, and a
+// stream without people is empty.
+func (h *PersonHandler) streamPeople(w http.ResponseWriter, req *http.Request, filter bson.M, opts *FindOptions) {
+	h.stream(w, req, newNDJSONStream(w, h.writeTimeout), filter, opts)
+}
+
+// stream writes the people that match the filter to the stream, with the
+// stream timeout. An error before the first person is answered with a problem.
+func (h *PersonHandler) stream(w http.ResponseWriter, req *http.Request, stream *peopleStream, filter bson.M, opts *FindOptions) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.streamTimeout)
+	defer cancel()
+
+	err := h.store.StreamPeople(ctx, filter, opts, stream.write)
+	if err != nil && !stream.started {
+		writeError(w, req, err)
+		return
+	}
+
+	stream.finish(req, err)
+}
+
+// ExportPeople handles the HTTP GET request to download the person records
+// that match the filters, include_deleted and sort query parameters of
+// GetPeople as a CSV file, with a header row naming the column of each field
+// (see csvColumns). Every matching record is exported, so paging parameters
+// are ignored. The format parameter must be csv, which is also the default.
+// The rows are streamed as they are read, with the stream timeout, and the
+// outcome of the export is sent in trailers as with streamPeople.
+func (h *PersonHandler) ExportPeople(w http.ResponseWriter, req *http.Request) {
+	if format := req.URL.Query().Get("format"); format != "" && format != "csv" {
+		writeProblem(w, req, http.StatusBadRequest, fmt.Sprintf("format %q is not supported, only csv is", format))
+		return
+	}
+
+	filter, err := parsePeopleFilter(req)
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := parseFindOptions(req.URL.Query())
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.stream(w, req, newCSVStream(w, h.writeTimeout), filter, &FindOptions{Sort: opts.Sort})
+}
+
+// An importReport is the response to a CSV import: how many rows it read, how
+// many people it created and updated, and the errors of the rows that failed.
+type importReport struct {
+	DryRun  bool          `json:"dry_run"`
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Failed  int           `json:"failed"`
+	Errors  []importError `json:"errors"`
+}
+
+// An importProblem is the problem of a CSV import that failed midway, with the
+// report of the rows applied before.
+type importProblem struct {
+	*Problem
+	Report *importReport `json:"report"`
+}
+
+// An importError is the error of a row of a CSV import, see batchItem.
+type importError struct {
+	Line          int         `json:"line"`
+	Status        int         `json:"status"`
+	ID            string      `json:"id,omitempty"`
+	Error         string      `json:"error"`
+	InvalidParams []Violation `json:"invalid-params,omitempty"`
+}
+
+// ImportPeople handles the HTTP POST request to import people from a CSV file
+// with a header row, see newCSVImporter. Rows with an id update that person,
+// and the others create one. The file is read as it arrives, and applied in
+// batches of at most batchLimit rows, each of which may spend the store
+// timeout. A row that fails does not stop the others. With dry_run=true the
+// rows are only checked against the store, and nothing is written. If the file
+// cannot be read to its end, the problem reports the rows applied before. The response reports the
+// number of people created and updated, and the error of each row that failed.
+func (h *PersonHandler) ImportPeople(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "" && !isMediaType(contentType, "text/csv") {
+		writeProblem(w, req, http.StatusUnsupportedMediaType, "Imports require a Content-Type of text/csv.")
+		return
+	}
+
+	dryRun, err := parseBoolParam(req, "dry_run")
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	importer, err := newCSVImporter(req.Body)
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	report := importReport{DryRun: dryRun, Errors: []importError{}}
+	for {
+		// Each batch restarts the read and write deadlines of the server, so
+		// that a large file is not cut off as long as it keeps arriving.
+		_ = rc.SetReadDeadline(time.Now().Add(h.readTimeout))
+		_ = rc.SetWriteDeadline(time.Now().Add(h.writeTimeout))
+
+		rows, err := importer.next(h.batchLimit)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				status = http.StatusRequestTimeout
+			}
+
+			// The rows already applied stay applied, so they are reported.
+			problem := newProblem(req, status, fmt.Sprintf("Reading the CSV file failed after %d rows: %v", report.Rows, err))
+			writeExtendedProblem(w, req, problem, importProblem{Problem: problem, Report: &report})
+			return
+		}
+
+		if len(rows) == 0 {
+			break
+		}
+
+		if err = h.importRows(req, rows, dryRun, &report); err != nil {
+			problem := errorProblem(req, err)
+			writeExtendedProblem(w, req, problem, importProblem{Problem: problem, Report: &report})
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// importRows applies the rows of a CSV import that could be read, unless
+// dryRun is set, and adds their outcome to the report. The creates are applied
+// as a batch, and the updates are merged into the stored people one at a time.
+// The error is only set when the batch as a whole could not be applied.
+func (h *PersonHandler) importRows(req *http.Request, rows []csvRow, dryRun bool, report *importReport) error {
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	var creates []BatchOperation
+	for _, row := range rows {
+		if row.Err == nil && row.Op.Op == BatchCreate {
+			creates = append(creates, row.Op)
+		}
+	}
+
+	results := make([]BatchResult, len(creates))
+	if dryRun && len(creates) > 0 {
+		// The creates are not applied, so the caller's role is checked as
+		// the batch would check it.
+		if err := h.checkPermission(ctx, PermissionCreate); err != nil {
+			return err
+		}
+	} else if len(creates) > 0 {
+		var err error
+		if results, err = h.store.ApplyBatch(ctx, creates, false); err != nil {
+			return err
+		}
+	}
+
+	report.Rows += len(rows)
+	created := 0
+	for _, row := range rows {
+		err := row.Err
+		if err == nil && row.Op.Op == BatchCreate {
+			err = results[created].Err
+			created++
+		} else if err == nil {
+			err = h.importUpdate(ctx, row, dryRun)
+		}
+
+		if err != nil {
+			problem := errorProblem(req, err)
+			report.Failed++
+			report.Errors = append(report.Errors, importError{Line: row.Line, Status: problem.Status, ID: row.Op.ID, Error: problem.Detail, InvalidParams: problem.InvalidParams})
+		} else if row.Op.Op == BatchCreate {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+
+	return nil
+}
+
+// importUpdate merges the columns of an update row of a CSV import into the
+// stored person. A dry run only checks that the caller may update people, and
+// that the person exists, is at the version of the row, and would still be
+// valid after the update.
+func (h *PersonHandler) importUpdate(ctx context.Context, row csvRow, dryRun bool) error {
+	patch := IfVersion(row.Patch, row.Op.versions()...)
+	if !dryRun {
+		_, err := h.store.PatchPersonRecord(ctx, patch, row.Op.ID)
+		return err
+	}
+
+	if err := h.checkPermission(ctx, PermissionUpdate); err != nil {
+		return err
+	}
+
+	stored, err := h.store.GetPersonByObjectId(ctx, row.Op.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = patch.Apply(*stored)
+	return err
+}
+
 // GetPerson handles the HTTP GET request to retrieve a single person record by ID.
 // It retrieves the person ID from the request parameters, fetches the record
-// from the database, and returns it as a JSON response.
-func GetPerson(w http.ResponseWriter, req *http.Request) {
+// from the database, and returns it as a JSON response with its version as the
+// ETag, or 304 Not Modified if the If-None-Match header matches the ETag. A
+// deleted record is only returned with include_deleted=true.
+func (h *PersonHandler) GetPerson(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	params := mux.Vars(req)
 	id := params["id"]
 
-	person, err := GetPersonByObjectId(id)
+	includeDeleted, err := parseBoolParam(req, "include_deleted")
 	if err != nil {
-		if err.Error() == "person not found" {
-			http.Error(w, "Person not found", http.StatusNotFound)
-			return
-		}
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var person *Person
+	if includeDeleted {
+		person, err = h.getPersonIncludingDeleted(ctx, id)
+	} else {
+		person, err = h.store.GetPersonByObjectId(ctx, id)
+	}
+
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	setETag(w, person)
+	if matchesIfNoneMatch(req, ETag(person.Version)) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	json.NewEncoder(w).Encode(person)
 }
 
-// PatchPerson handles HTTP PATCH requests to update a person's record.
-// It currently supports only "replace" operations.
-func PatchPerson(w http.ResponseWriter, req *http.Request) {
+// getPersonIncludingDeleted retrieves a person record by ID whether or not it is deleted.
+func (h *PersonHandler) getPersonIncludingDeleted(ctx context.Context, id string) (*Person, error) {
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	people, err := h.store.GetAllPeople(ctx, bson.M{"_id": objectId}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(people) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return people[0], nil
+}
+
+// GetPersonHistory handles the HTTP GET request to list the changes to a person
+// record by ID. It returns the audit events of the record as a JSON array,
+// oldest first, including those of a deleted record.
+func (h *PersonHandler) GetPersonHistory(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var patch Patch
-	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	params := mux.Vars(req)
+	id := params["id"]
+
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	events, err := h.store.GetPersonHistory(ctx, id)
+	if err != nil {
+		writeError(w, req, err)
 		return
 	}
 
-	if patch.Op == "replace" {
-		params := mux.Vars(req)
-		id := params["id"]
+	json.NewEncoder(w).Encode(events)
+}
+
+// PatchPerson handles HTTP PATCH requests to update a person's record.
+// The body is either a RFC 6902 JSON Patch document, an array of add, remove,
+// replace, move, copy and test operations, or with a Content-Type of
+// application/merge-patch+json a RFC 7396 JSON Merge Patch document.
+// Either is applied atomically to the record. With an If-Match header the
+// patch is only applied if the ETag of the record matches, and fails with 412
+// Precondition Failed otherwise.
+func (h *PersonHandler) PatchPerson(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-		result, err := PatchPersonRecord(patch, id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	var patch PersonPatch
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	case isMediaType(contentType, "application/merge-patch+json"):
+		var mergePatch json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&mergePatch); err != nil {
+			writeProblem(w, req, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		json.NewEncoder(w).Encode(result)
-	} else {
-		http.Error(w, "PATCH currently only supports 'replace' operations.", http.StatusBadRequest)
+		if err := ValidateMergePatch(MergePatch(mergePatch)); err != nil {
+			writeProblem(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		patch = MergePatch(mergePatch)
+	case contentType == "" || isMediaType(contentType, "application/json-patch+json", "application/json"):
+		var jsonPatch JSONPatch
+		if err := json.NewDecoder(req.Body).Decode(&jsonPatch); err != nil {
+			writeProblem(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := ValidatePatch(jsonPatch); err != nil {
+			writeProblem(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		patch = jsonPatch
+	default:
+		writeProblem(w, req, http.StatusUnsupportedMediaType, "PATCH requires a Content-Type of application/json-patch+json or application/merge-patch+json.")
+		return
+	}
+
+	versions, ok := ifMatchVersions(w, req)
+	if !ok {
+		return
+	}
+
+	if len(versions) > 0 {
+		patch = IfVersion(patch, versions...)
+	}
+
+	params := mux.Vars(req)
+	id := params["id"]
+
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	result, err := h.store.PatchPersonRecord(ctx, patch, id)
+	if err != nil {
+		writeError(w, req, err)
+		return
 	}
+
+	setETag(w, result)
+	json.NewEncoder(w).Encode(result)
 }
 
 // UpdatePerson handles the HTTP PUT request to update an existing person record.
-// It decodes the JSON request body into a Person struct, retrieves the person ID
-// from the request parameters, updates the record in the database, and returns
-// the result as a JSON response.
-func UpdatePerson(w http.ResponseWriter, req *http.Request) {
+// It decodes and validates the JSON request body into a Person struct, retrieves
+// the person ID from the request parameters, updates the record in the database,
+// and returns the result as a JSON response. With an If-Match header the record
+// is only updated if its ETag matches, and fails with 412 Precondition Failed
+// otherwise.
+func (h *PersonHandler) UpdatePerson(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var person Person
-	if err := json.NewDecoder(req.Body).Decode(&person); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	person, ok := decodePerson(w, req)
+	if !ok {
+		return
+	}
+
+	versions, ok := ifMatchVersions(w, req)
+	if !ok {
 		return
 	}
 
 	params := mux.Vars(req)
 	id := params["id"]
 
-	result, err := UpdatePersonRecord(person, id)
+	ctx, cancel := h.storeContext(req)
+	defer cancel()
+
+	result, err := h.store.UpdatePersonRecord(ctx, person, id, versions...)
 	if err != nil {
-		if err.Error() == "person not found" {
-			http.Error(w, "Person not found", http.StatusNotFound)
+		writeError(w, req, err)
+		return
+	}
+
+	setETag(w, result)
+	json.NewEncoder(w).Encode(result)
+}
+
+// A batchItem is the result of an operation in the response to a batch: its
+// status code, the id and version of the person, and the error it failed with.
+type batchItem struct {
+	Status        int         `json:"status"`
+	ID            string      `json:"id,omitempty"`
+	Version       int64       `json:"version,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	InvalidParams []Violation `json:"invalid-params,omitempty"`
+}
+
+// BatchPeople handles the HTTP POST request to create, update and delete people
+// in bulk. The body is a JSON array of at most batchLimit operations, see
+// BatchOperation, and the response lists the result of each in order. The
+// operations that succeed are applied even if others fail, unless atomic=true
+// is given: then either every operation is applied or none is.
+func (h *PersonHandler) BatchPeople(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	atomic, err := parseBoolParam(req, "atomic")
+	if err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var ops []BatchOperation
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&ops); err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(ops) == 0 {
+		writeProblem(w, req, http.StatusBadRequest, "The batch has no operations.")
+		return
+	}
+
+	if len(ops) > h.batchLimit {
+		writeProblem(w, req, http.StatusRequestEntityTooLarge, fmt.Sprintf("The batch has %d operations, more than the limit of %d.", len(ops), h.batchLimit))
+		return
+	}
+
+	// Invalid operations never reach the store, and abort an atomic batch.
+	results := make([]BatchResult, len(ops))
+	var valid []BatchOperation
+	var indexes []int
+	aborted := false
+	for i, op := range ops {
+		if results[i].Err = op.Validate(); results[i].Err == nil {
+			valid = append(valid, op)
+			indexes = append(indexes, i)
+		} else if atomic {
+			abortBatch(results, i)
+			aborted = true
+			break
+		}
+	}
+
+	if !aborted && len(valid) > 0 {
+		ctx, cancel := h.storeContext(req)
+		defer cancel()
+
+		applied, err := h.store.ApplyBatch(ctx, valid, atomic)
+		if err != nil {
+			writeError(w, req, err)
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		for i, result := range applied {
+			results[indexes[i]] = result
+		}
 	}
 
-	json.NewEncoder(w).Encode(result)
+	items := make([]batchItem, len(ops))
+	for i, result := range results {
+		items[i] = newBatchItem(req, ops[i], result)
+	}
+
+	json.NewEncoder(w).Encode(items)
+}
+
+// newBatchItem returns the item of the response for the result of the operation.
+func newBatchItem(req *http.Request, op BatchOperation, result BatchResult) batchItem {
+	if result.Err != nil {
+		problem := errorProblem(req, result.Err)
+		return batchItem{Status: problem.Status, ID: op.ID, Error: problem.Detail, InvalidParams: problem.InvalidParams}
+	}
+
+	return batchItem{Status: http.StatusOK, ID: result.Person.ID.Hex(), Version: result.Person.Version}
+}
+
+// decodePerson decodes the request body into a Person, rejecting unknown fields,
+// and validates it. If the person is not valid it replies with a problem and
+// returns false.
+func decodePerson(w http.ResponseWriter, req *http.Request) (Person, bool) {
+	var person Person
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&person); err != nil {
+		writeProblem(w, req, http.StatusBadRequest, err.Error())
+		return Person{}, false
+	}
+
+	if err := ValidatePerson(person); err != nil {
+		writeError(w, req, err)
+		return Person{}, false
+	}
+
+	return person, true
+}
+
+// parsePeopleFilter parses the filters of the query parameters, see parseQuery,
+// leaving out the deleted records unless include_deleted=true is given.
+func parsePeopleFilter(req *http.Request) (bson.M, error) {
+	filter, err := parseQuery(req.URL.Query(), getPeopleQueryFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	includeDeleted, err := parseBoolParam(req, "include_deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	if !includeDeleted {
+		filter = notDeleted(filter)
+	}
+
+	return filter, nil
 }
 
 func getPeopleQueryFilter() []QueryFilter {
@@ -164,35 +754,60 @@ func getPeopleQueryFilter() []QueryFilter {
 		{Name: "lastname"},
 		{Name: "city", ParentPath: "location"},
 		{Name: "country", ParentPath: "location"},
+		{Name: "employee_number"},
+		{Name: "email"},
+		{Name: "phone"},
+		{Name: "job_title", ParentPath: "employment"},
+		{Name: "department", ParentPath: "employment"},
+		{Name: "manager_id", ParentPath: "employment"},
+		{Name: "start_date", ParentPath: "employment"},
+		{Name: "end_date", ParentPath: "employment"},
+		{Name: "status", ParentPath: "employment"},
+		{Name: "salary_band", ParentPath: "employment"},
 	}
 }
 
-// parseQuery parses the query parameters from an HTTP request into MongoDB filter criteria.
-// It takes the URL query values and a list of QueryFilter structs, constructs filter criteria
-// based on the query parameters, and returns a BSON filter document suitable for MongoDB queries.
-func parseQuery(queryValues url.Values, queryFilters []QueryFilter) bson.M {
-	filter := bson.M{}
-	if len(queryValues) != 0 {
-		for _, queryFilter := range queryFilters {
-			item, found := queryValues[queryFilter.Name]
-			if found {
-				var names []string
-				if len(item) == 1 {
-					names = strings.Split(item[0], ",")
-				} else {
-					names = item
-				}
-
-				jsonPath := queryFilter.Name
-				if queryFilter.ParentPath != "" {
-					jsonPath = queryFilter.ParentPath + "." + jsonPath
-				}
-
-				primitive := bson.M{"$in": names}
-				filter[jsonPath] = primitive
-			}
+// ifMatchVersions returns the versions the If-Match header of the request
+// allows, or none if any version will do. If the header cannot match any
+// version it replies with 412 Precondition Failed and returns false.
+func ifMatchVersions(w http.ResponseWriter, req *http.Request) ([]int64, bool) {
+	versions, err := parseIfMatch(req)
+	if err != nil {
+		writeError(w, req, err)
+		return nil, false
+	}
+
+	return versions, true
+}
+
+// parseBoolParam parses a boolean query parameter, such as include_deleted,
+// which is false by default.
+func parseBoolParam(req *http.Request, name string) (bool, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+
+	return result, nil
+}
+
+// isMediaType reports whether the Content-Type header value is one of the given media types.
+func isMediaType(contentType string, mediaTypes ...string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range mediaTypes {
+		if mediaType == t {
+			return true
 		}
 	}
 
-	return filter
+	return false
 }
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeStore is a PersonStore that records the calls made by the handlers.
+type fakeStore struct {
+	people  map[string]Person
+	query   bson.M
+	opts    *FindOptions
+	patched PersonPatch
+	batch   []BatchOperation
+	atomic  bool
+	pingErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{people: map[string]Person{
+		"1": {Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+	}}
+}
+
+func (s *fakeStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *fakeStore) Mode() string {
+	return StorageMemory
+}
+
+func (s *fakeStore) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s *fakeStore) CreatePersonRecord(ctx context.Context, person Person) (*Person, error) {
+	s.people["2"] = person
+	return &person, nil
+}
+
+func (s *fakeStore) DeletePersonRecord(ctx context.Context, id string, versions ...int64) (*mongo.DeleteResult, error) {
+	if _, ok := s.people[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	delete(s.people, id)
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (s *fakeStore) RestorePersonRecord(ctx context.Context, id string) (*Person, error) {
+	return s.GetPersonByObjectId(ctx, id)
+}
+
+func (s *fakeStore) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) CountPeople(ctx context.Context, query bson.M) (int64, error) {
+	return int64(len(s.people)), nil
+}
+
+func (s *fakeStore) GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) ([]*Person, error) {
+	s.query = query
+	s.opts = opts
+	people := make([]*Person, 0, len(s.people))
+	for _, person := range s.people {
+		people = append(people, person.Clone())
+	}
+
+	return people, nil
+}
+
+func (s *fakeStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error {
+	people, err := s.GetAllPeople(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, person := range people {
+		if err = fn(person); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeStore) GetPersonByObjectId(ctx context.Context, id string) (*Person, error) {
+	person, ok := s.people[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &person, nil
+}
+
+func (s *fakeStore) PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (*Person, error) {
+	s.patched = patch
+	return s.GetPersonByObjectId(ctx, id)
+}
+
+func (s *fakeStore) UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (*Person, error) {
+	if _, ok := s.people[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	s.people[id] = person
+	return &person, nil
+}
+
+func (s *fakeStore) GetPersonHistory(ctx context.Context, id string) ([]AuditEvent, error) {
+	if _, ok := s.people[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	return []AuditEvent{}, nil
+}
+
+func (s *fakeStore) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	s.batch = ops
+	s.atomic = atomic
+	results := make([]BatchResult, len(ops))
+	for i := range ops {
+		results[i].Person = &Person{Version: 1}
+	}
+
+	return results, nil
+}
+
+func serve(store PersonStore, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+	return rec
+}
+
+// TestCreatePerson tests the POST /person route.
+func TestCreatePerson(t *testing.T) {
+	store := newFakeStore()
+	rec := serve(store, http.MethodPost, "/person", `{"firstname":"Emma","lastname":"Jones"}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Emma", store.people["2"].Firstname)
+
+	rec = serve(store, http.MethodPost, "/person", `{"firstname":`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	rec = serve(store, http.MethodPost, "/person", `{"firstname":"Emma","lastname":"Jones","nickname":"Em"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "nickname")
+}
+
+// TestCreatePersonValidation tests that invalid people are rejected with a problem listing each violation.
+func TestCreatePersonValidation(t *testing.T) {
+	store := newFakeStore()
+	rec := serve(store, http.MethodPost, "/person", `{"firstname":"","location":{"country":"Narnia"}}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, http.StatusUnprocessableEntity, problem.Status)
+	assert.Equal(t, "/person", problem.Instance)
+	assert.Equal(t, []Violation{
+		{Name: "firstname", Reason: "is required"},
+		{Name: "lastname", Reason: "is required"},
+		{Name: "location.country", Reason: `"Narnia" is not an ISO 3166-1 alpha-2 country code`},
+	}, problem.InvalidParams)
+	assert.Len(t, store.people, 1)
+}
+
+// TestDeletePerson tests the DELETE /person/{id} route.
+func TestDeletePerson(t *testing.T) {
+	store := newFakeStore()
+
+	rec := serve(store, http.MethodDelete, "/person/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, store.people)
+
+	rec = serve(store, http.MethodDelete, "/person/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestSoftDelete tests that DELETE /person/{id} hides a person until they are
+// restored with POST /person/{id}/restore, unless include_deleted=true is given.
+func TestSoftDelete(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{
+		{Firstname: "John", Lastname: "Smith"},
+		{Firstname: "Emma", Lastname: "Jones"},
+	})
+
+	people, err := store.GetAllPeople(context.Background(), bson.M{"firstname": "John"}, nil)
+	assert.NoError(t, err)
+	id := people[0].ID.Hex()
+
+	assert.Equal(t, http.StatusOK, serve(store, http.MethodDelete, "/person/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(store, http.MethodDelete, "/person/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(store, http.MethodGet, "/person/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(store, http.MethodPut, "/person/"+id, `{"firstname":"John","lastname":"Smith"}`).Code)
+
+	rec := serve(store, http.MethodGet, "/person", "")
+	assert.Equal(t, "1", rec.Header().Get("X-Total-Count"))
+	assert.NotContains(t, rec.Body.String(), "John")
+
+	rec = serve(store, http.MethodGet, "/person?include_deleted=true", "")
+	assert.Equal(t, "2", rec.Header().Get("X-Total-Count"))
+
+	var deleted Person
+	rec = serve(store, http.MethodGet, "/person/"+id+"?include_deleted=true", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &deleted))
+	assert.NotNil(t, deleted.DeletedAt)
+
+	var restored Person
+	rec = serve(store, http.MethodPost, "/person/"+id+"/restore", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &restored))
+	assert.Nil(t, restored.DeletedAt)
+
+	assert.Equal(t, http.StatusConflict, serve(store, http.MethodPost, "/person/"+id+"/restore", "").Code)
+	assert.Equal(t, http.StatusOK, serve(store, http.MethodGet, "/person/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(store, http.MethodPost, "/person/"+primitive.NewObjectID().Hex()+"/restore", "").Code)
+
+	// The deletion time is set by the store only.
+	rec = serve(store, http.MethodPut, "/person/"+id, `{"firstname":"John","lastname":"Smith","deleted_at":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(store, http.MethodPatch, "/person/"+id, `[{"op":"add","path":"/deleted_at","value":"2020-01-01T00:00:00Z"}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusOK, serve(store, http.MethodGet, "/person/"+id, "").Code)
+
+	events, err := store.GetPersonHistory(context.Background(), id)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 4) {
+		assert.Equal(t, AuditDelete, events[0].Action)
+		assert.Equal(t, "deleted_at", events[0].Changes[0].Field)
+		assert.Equal(t, AuditRestore, events[1].Action)
+	}
+}
+
+// TestGetPeople tests that the GET /person route passes the parsed query to the store.
+func TestGetPeople(t *testing.T) {
+	store := newFakeStore()
+	rec := serve(store, http.MethodGet, "/person?city=London,Paris", "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, notDeleted(bson.M{"location.city": bson.M{"$in": []string{"London", "Paris"}}}), store.query)
+
+	assert.Equal(t, &FindOptions{Limit: defaultPageSize}, store.opts)
+	assert.Equal(t, "1", rec.Header().Get("X-Total-Count"))
+
+	var people []Person
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &people))
+	assert.Len(t, people, 1)
+
+	rec = serve(store, http.MethodGet, "/person?limit=10&offset=20&sort=-lastname&fields=firstname,location.city", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, &FindOptions{
+		Limit:  10,
+		Offset: 20,
+		Sort:   []SortField{{Path: "lastname", Descending: true}},
+		Fields: []string{"firstname", "location.city", "lastname"},
+	}, store.opts)
+	assert.NotContains(t, rec.Body.String(), "Smith")
+
+	rec = serve(store, http.MethodGet, "/person?city=London&include_deleted=true", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, bson.M{"location.city": bson.M{"$in": []string{"London"}}}, store.query)
+
+	rec = serve(store, http.MethodGet, "/person?sort=salary", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(store, http.MethodGet, "/person?include_deleted=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A page past the end is empty, with the paging headers.
+	memory := NewMemoryStore()
+	memory.seed(People{{Firstname: "John", Lastname: "Smith"}})
+	rec = serve(memory, http.MethodGet, "/person?offset=5", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-Total-Count"))
+	assert.Contains(t, rec.Header().Get("Link"), `rel="last"`)
+	assert.JSONEq(t, "[]", rec.Body.String())
+}
+
+// TestGetPerson tests the GET /person/{id} route.
+func TestGetPerson(t *testing.T) {
+	store := newFakeStore()
+
+	rec := serve(store, http.MethodGet, "/person/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"firstname":"John"`)
+
+	rec = serve(store, http.MethodGet, "/person/2", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestPatchPerson tests the PATCH /person/{id} route.
+func TestPatchPerson(t *testing.T) {
+	store := newFakeStore()
+
+	rec := serve(store, http.MethodPatch, "/person/1", `[{"op":"replace","path":"/firstname","value":"Jack"}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, JSONPatch{{Op: "replace", Path: "/firstname", Value: json.RawMessage(`"Jack"`)}}, store.patched)
+
+	rec = serve(store, http.MethodPatch, "/person/1", `{"op":"replace","path":"/firstname","value":"Jack"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(store, http.MethodPatch, "/person/1", `[{"op":"rename","path":"/firstname"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(store, http.MethodPatch, "/person/1", `[{"op":"replace","path":"firstname","value":"Jack"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(store, http.MethodPatch, "/person/2", `[{"op":"remove","path":"/firstname"}]`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestPatchPersonContentType tests that PATCH only accepts JSON Patch documents.
+func TestPatchPersonContentType(t *testing.T) {
+	store := newFakeStore()
+
+	for contentType, code := range map[string]int{
+		"application/json-patch+json":                http.StatusOK,
+		"application/json-patch+json; charset=utf-8": http.StatusOK,
+		"application/json":                           http.StatusOK,
+		"application/merge-patch+json":               http.StatusBadRequest,
+		"text/plain":                                 http.StatusUnsupportedMediaType,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/person/1", strings.NewReader(`[{"op":"test","path":"/firstname","value":"John"}]`))
+		req.Header.Set("Content-Type", contentType)
+		NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+
+		assert.Equal(t, code, rec.Code, contentType)
+	}
+}
+
+// TestMergePatchPerson tests the PATCH /person/{id} route with a JSON Merge Patch.
+func TestMergePatchPerson(t *testing.T) {
+	store := newFakeStore()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/person/1", strings.NewReader(`{"firstname":"Jack","location":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, MergePatch(`{"firstname":"Jack","location":null}`), store.patched)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPatch, "/person/1", strings.NewReader(`["firstname"]`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestUpdatePerson tests the PUT /person/{id} route.
+func TestUpdatePerson(t *testing.T) {
+	store := newFakeStore()
+
+	rec := serve(store, http.MethodPut, "/person/1", `{"firstname":"Jack","lastname":"Smith"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Jack", store.people["1"].Firstname)
+
+	rec = serve(store, http.MethodPut, "/person/2", `{"firstname":"Jack","lastname":"Smith"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestWriteError tests that store errors are mapped to the right status codes.
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		Err    error
+		Status int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: %q", ErrInvalidID, "1"), http.StatusBadRequest},
+		{fmt.Errorf("%w: duplicate key", ErrConflict), http.StatusConflict},
+		{&PatchError{Index: 0, Op: Patch{Op: "test", Path: "/firstname"}, Err: "test failed"}, http.StatusConflict},
+		{&PatchError{Index: -1, Err: "result is not a valid person"}, http.StatusUnprocessableEntity},
+		{&ValidationError{Violations: []Violation{{Name: "firstname", Reason: "is required"}}}, http.StatusUnprocessableEntity},
+		{fmt.Errorf("finding people: %w", context.Canceled), StatusClientClosedRequest},
+		{fmt.Errorf("finding people: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Err.Error(), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, httptest.NewRequest(http.MethodGet, "/person/1", nil), tc.Err)
+
+			assert.Equal(t, tc.Status, rec.Code)
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		})
+	}
+}
+
+// slowStore is a PersonStore that blocks every read until the context is done.
+type slowStore struct {
+	*fakeStore
+}
+
+func (s *slowStore) GetPersonByObjectId(ctx context.Context, id string) (*Person, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// TestStoreContext tests that the store is given a context that ends with the
+// store timeout or when the client goes away.
+func TestStoreContext(t *testing.T) {
+	store := &slowStore{newFakeStore()}
+	cfg := DefaultConfig()
+	cfg.StoreTimeout = 10 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	NewRouter(store, cfg, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/person/1", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec = httptest.NewRecorder()
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/person/1", nil).WithContext(ctx))
+	assert.Equal(t, StatusClientClosedRequest, rec.Code)
+
+	var problem Problem
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	assert.Equal(t, "Client Closed Request", problem.Title)
+}
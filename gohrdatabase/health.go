@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A HealthHandler serves the liveness, readiness and status routes used by
+// orchestrators and operators to check on the server.
+type HealthHandler struct {
+	store   PersonStore
+	storage string
+	timeout time.Duration
+	started time.Time
+}
+
+// A StorageStatus describes the store the server is using.
+type StorageStatus struct {
+	// Mode is the storage in use, which differs from Configured when the
+	// server fell back to in-memory storage.
+	Mode       string  `json:"mode"`
+	Configured string  `json:"configured"`
+	Fallback   bool    `json:"fallback"`
+	Connected  bool    `json:"connected"`
+	PingMillis float64 `json:"ping_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// A ServerStatus is the body of the /status route.
+type ServerStatus struct {
+	Status  string        `json:"status"`
+	Version string        `json:"version"`
+	Uptime  string        `json:"uptime"`
+	Storage StorageStatus `json:"storage"`
+	Records *int64        `json:"records,omitempty"`
+}
+
+// NewHealthHandler returns a HealthHandler for the store, which was opened for
+// the storage mode in cfg. Checks on the store time out after cfg.StoreTimeout.
+func NewHealthHandler(store PersonStore, cfg *Config) *HealthHandler {
+	return &HealthHandler{store: store, storage: cfg.Storage, timeout: cfg.StoreTimeout, started: time.Now()}
+}
+
+// Live handles GET /healthz, reporting that the process is running.
+func (h *HealthHandler) Live(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Ready handles GET /readyz, reporting whether the store can serve requests.
+// It replies with 503 Service Unavailable if the store cannot be pinged.
+func (h *HealthHandler) Ready(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+
+	storage := h.checkStorage(ctx)
+	if !storage.Connected {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "storage": storage})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "storage": storage})
+}
+
+// Status handles GET /status, reporting the storage mode and whether the server
+// fell back to in-memory storage, the store ping latency, the number of people
+// and the build version. The status is "degraded" when the server fell back to
+// in-memory storage and "unavailable" when the store cannot be reached, in which
+// case it replies with 503 Service Unavailable.
+func (h *HealthHandler) Status(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	defer cancel()
+
+	status := ServerStatus{
+		Status:  "ok",
+		Version: buildVersion(),
+		Uptime:  time.Since(h.started).Round(time.Second).String(),
+		Storage: h.checkStorage(ctx),
+	}
+
+	code := http.StatusOK
+	switch {
+	case !status.Storage.Connected:
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	case status.Storage.Fallback:
+		status.Status = "degraded"
+	}
+
+	if status.Storage.Connected {
+		if count, err := h.store.CountPeople(ctx, notDeleted(bson.M{})); err == nil {
+			status.Records = &count
+		} else {
+			status.Storage.Error = err.Error()
+		}
+	}
+
+	writeJSON(w, code, status)
+}
+
+// checkStorage pings the store and reports how long it took.
+func (h *HealthHandler) checkStorage(ctx context.Context) StorageStatus {
+	status := StorageStatus{
+		Mode:       h.store.Mode(),
+		Configured: h.storage,
+	}
+
+	status.Fallback = h.storage == StorageAuto && status.Mode == StorageMemory
+
+	start := time.Now()
+	err := h.store.Ping(ctx)
+	status.PingMillis = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Connected = true
+	}
+
+	return status
+}
+
+// writeJSON replies with the value encoded as JSON and the status code.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getStatus(store PersonStore, cfg *Config, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return getStatusWithAuth(store, cfg, nil, target)
+}
+
+// getStatusWithAuth requests the health route of a router authenticating with auth.
+func getStatusWithAuth(store PersonStore, cfg *Config, auth *Authenticator, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	NewRouter(store, cfg, auth).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return rec, body
+}
+
+// TestHealthz tests that the liveness route does not depend on the store.
+func TestHealthz(t *testing.T) {
+	store := newFakeStore()
+	store.pingErr = errors.New("connection refused")
+
+	rec, body := getStatus(store, DefaultConfig(), "/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", body["status"])
+}
+
+// TestReadyz tests that the readiness route fails when the store cannot be pinged.
+func TestReadyz(t *testing.T) {
+	store := newFakeStore()
+	rec, body := getStatus(store, DefaultConfig(), "/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ready", body["status"])
+
+	store.pingErr = errors.New("connection refused")
+	rec, body = getStatus(store, DefaultConfig(), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "unavailable", body["status"])
+	assert.Equal(t, "connection refused", body["storage"].(map[string]interface{})["error"])
+
+	cfg := DefaultConfig()
+	cfg.StoreTimeout = 20 * time.Millisecond
+	rec, _ = getStatus(newUnreachableMongoStore(t), cfg, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+// TestStatus tests that the status route reports the storage mode, fallback and record count.
+func TestStatus(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(3))
+
+	tests := []struct {
+		Name     string
+		Storage  string
+		Status   string
+		Fallback bool
+	}{
+		{"memory", StorageMemory, "ok", false},
+		{"fallback", StorageAuto, "degraded", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Storage = tc.Storage
+
+			rec, body := getStatus(store, cfg, "/status")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tc.Status, body["status"])
+			assert.Equal(t, buildVersion(), body["version"])
+			assert.Equal(t, float64(3), body["records"])
+
+			storage := body["storage"].(map[string]interface{})
+			assert.Equal(t, StorageMemory, storage["mode"])
+			assert.Equal(t, tc.Storage, storage["configured"])
+			assert.Equal(t, tc.Fallback, storage["fallback"])
+			assert.Equal(t, true, storage["connected"])
+			assert.Contains(t, storage, "ping_ms")
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.StoreTimeout = 20 * time.Millisecond
+	rec, body := getStatus(newUnreachableMongoStore(t), cfg, "/status")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "unavailable", body["status"])
+	assert.NotContains(t, body, "records")
+}
+
+// TestStatusWithAuth tests that the unauthenticated status route still counts
+// the records when the /person routes require authentication.
+func TestStatusWithAuth(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(3))
+
+	cfg := DefaultConfig()
+	cfg.Storage = StorageMemory
+	rec, body := getStatusWithAuth(store, cfg, newTestAuthenticator(t), "/status")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", body["status"])
+	assert.Equal(t, float64(3), body["records"])
+	assert.NotContains(t, body["storage"], "error")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// An instrumentedStore is a PersonStore that records the duration and errors
+// of each operation of the store it wraps, and logs them with the logger of
+// the request.
+type instrumentedStore struct {
+	PersonStore
+	metrics *Metrics
+}
+
+// InstrumentStore returns store with the timing of its operations recorded in metrics and logged.
+func InstrumentStore(store PersonStore, metrics *Metrics) PersonStore {
+	return &instrumentedStore{PersonStore: store, metrics: metrics}
+}
+
+// checkPermission checks the permission with the store it wraps, if that is a
+// permissionChecker, and otherwise allows it.
+func (s *instrumentedStore) checkPermission(ctx context.Context, permission Permission) error {
+	if checker, ok := s.PersonStore.(permissionChecker); ok {
+		return checker.checkPermission(ctx, permission)
+	}
+
+	return nil
+}
+
+func (s *instrumentedStore) observe(ctx context.Context, operation string, start time.Time, err error) {
+	duration := time.Since(start)
+	s.metrics.observeStore(operation, duration, err)
+
+	attrs := []slog.Attr{
+		slog.String("operation", operation),
+		slog.Float64("latency_ms", float64(duration.Microseconds())/1000),
+	}
+
+	// Errors caused by the request are reported to the client, so only failures
+	// of the store itself are logged above debug level.
+	level := slog.LevelDebug
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		switch errorKind(err) {
+		case "canceled", "timeout":
+			level = slog.LevelWarn
+		case "internal":
+			level = slog.LevelError
+		}
+	}
+
+	Logger(ctx).LogAttrs(ctx, level, "store operation", attrs...)
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "ping", start, err) }(time.Now())
+	return s.PersonStore.Ping(ctx)
+}
+
+func (s *instrumentedStore) CreatePersonRecord(ctx context.Context, person Person) (result *Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "create", start, err) }(time.Now())
+	return s.PersonStore.CreatePersonRecord(ctx, person)
+}
+
+func (s *instrumentedStore) DeletePersonRecord(ctx context.Context, id string, versions ...int64) (result *mongo.DeleteResult, err error) {
+	defer func(start time.Time) { s.observe(ctx, "delete", start, err) }(time.Now())
+	return s.PersonStore.DeletePersonRecord(ctx, id, versions...)
+}
+
+func (s *instrumentedStore) RestorePersonRecord(ctx context.Context, id string) (person *Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "restore", start, err) }(time.Now())
+	return s.PersonStore.RestorePersonRecord(ctx, id)
+}
+
+func (s *instrumentedStore) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (purged int64, err error) {
+	defer func(start time.Time) { s.observe(ctx, "purge", start, err) }(time.Now())
+	return s.PersonStore.PurgeDeletedPeople(ctx, deletedBefore)
+}
+
+func (s *instrumentedStore) CountPeople(ctx context.Context, query bson.M) (count int64, err error) {
+	defer func(start time.Time) { s.observe(ctx, "count", start, err) }(time.Now())
+	return s.PersonStore.CountPeople(ctx, query)
+}
+
+func (s *instrumentedStore) GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) (people []*Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "find", start, err) }(time.Now())
+	return s.PersonStore.GetAllPeople(ctx, query, opts)
+}
+
+// StreamPeople is observed once the stream ends, so its latency includes the
+// time spent writing the people to the client.
+func (s *instrumentedStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) (err error) {
+	defer func(start time.Time) { s.observe(ctx, "stream", start, err) }(time.Now())
+	return s.PersonStore.StreamPeople(ctx, query, opts, fn)
+}
+
+func (s *instrumentedStore) GetPersonByObjectId(ctx context.Context, id string) (person *Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "get", start, err) }(time.Now())
+	return s.PersonStore.GetPersonByObjectId(ctx, id)
+}
+
+func (s *instrumentedStore) PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (person *Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "patch", start, err) }(time.Now())
+	return s.PersonStore.PatchPersonRecord(ctx, patch, id)
+}
+
+func (s *instrumentedStore) UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (result *Person, err error) {
+	defer func(start time.Time) { s.observe(ctx, "update", start, err) }(time.Now())
+	return s.PersonStore.UpdatePersonRecord(ctx, person, id, versions...)
+}
+
+func (s *instrumentedStore) GetPersonHistory(ctx context.Context, id string) (events []AuditEvent, err error) {
+	defer func(start time.Time) { s.observe(ctx, "history", start, err) }(time.Now())
+	return s.PersonStore.GetPersonHistory(ctx, id)
+}
+
+func (s *instrumentedStore) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) (results []BatchResult, err error) {
+	defer func(start time.Time) { s.observe(ctx, "batch", start, err) }(time.Now())
+	return s.PersonStore.ApplyBatch(ctx, ops, atomic)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A PatchError reports a JSON Patch operation that could not be applied to a Person.
+// Index is the position of the failing operation, or -1 if the patched result was invalid.
+// A failing operation conflicts with the state of the person and unwraps to ErrConflict,
+// while an invalid result unwraps to ErrValidation.
+type PatchError struct {
+	Index int
+	Op    Patch
+	Err   string
+}
+
+func (e *PatchError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("patch: %s", e.Err)
+	}
+
+	return fmt.Sprintf("patch operation %d (%s %s): %s", e.Index, e.Op.Op, e.Op.Path, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	if e.Index < 0 {
+		return ErrValidation
+	}
+
+	return ErrConflict
+}
+
+// ValidatePatch checks that every operation in the patch document is well formed,
+// without applying it to a Person.
+func ValidatePatch(patch []Patch) error {
+	for i, op := range patch {
+		if err := validateOperation(op); err != nil {
+			return &PatchError{Index: i, Op: op, Err: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// A JSONPatch is a RFC 6902 JSON Patch document.
+type JSONPatch []Patch
+
+// Apply applies the JSON Patch document to a copy of the person.
+func (p JSONPatch) Apply(person Person) (*Person, error) {
+	return ApplyPatch(person, p)
+}
+
+// ApplyPatch applies a RFC 6902 JSON Patch document to a copy of the person.
+// The operations are applied in order against the JSON representation of the
+// person and either all of them succeed or the person is left unchanged.
+func ApplyPatch(person Person, patch []Patch) (*Person, error) {
+	if err := ValidatePatch(patch); err != nil {
+		return nil, err
+	}
+
+	doc, err := toJSONDocument(person)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range patch {
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, &PatchError{Index: i, Op: op, Err: err.Error()}
+		}
+	}
+
+	return fromJSONDocument(doc, person)
+}
+
+// toJSONDocument converts the person to its generic JSON representation.
+func toJSONDocument(person Person) (interface{}, error) {
+	data, err := json.Marshal(person)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// fromJSONDocument converts a patched JSON document back to a Person, keeping
+// the ID, deletion time and version of the original person, and validates the result.
+func fromJSONDocument(doc interface{}, original Person) (*Person, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject members that do not exist on a Person, rather than silently dropping them.
+	var result Person
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&result); err != nil {
+		return nil, &PatchError{Index: -1, Err: "result is not a valid person: " + err.Error()}
+	}
+
+	result.ID = original.ID
+	result.DeletedAt = original.DeletedAt
+	result.Version = original.Version
+	if err = ValidatePerson(result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func validateOperation(op Patch) error {
+	if _, err := parsePointer(op.Path); err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("missing value")
+		}
+	case "remove":
+	case "move", "copy":
+		if _, err := parsePointer(op.From); err != nil {
+			return fmt.Errorf("invalid from: %v", err)
+		}
+
+		if op.Op == "move" && op.Path != op.From && strings.HasPrefix(op.Path+"/", op.From+"/") {
+			return fmt.Errorf("cannot move a value into one of its children")
+		}
+	default:
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+
+	return nil
+}
+
+func applyOperation(doc interface{}, op Patch) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+
+	switch op.Op {
+	case "add":
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, value)
+	case "remove":
+		return removeValue(doc, path)
+	case "replace":
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = getValue(doc, path); err != nil {
+			return nil, err
+		}
+
+		return setValue(doc, path, value)
+	case "move":
+		from, _ := parsePointer(op.From)
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if doc, err = removeValue(doc, from); err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, value)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		return addValue(doc, path, deepCopy(value))
+	case "test":
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		actual, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(actual, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operation %q", op.Op)
+}
+
+// parsePointer splits a RFC 6901 JSON pointer into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func decodeValue(raw json.RawMessage) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", token)
+			}
+
+			current = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path not found: %s", token)
+		}
+	}
+
+	return current, nil
+}
+
+// updateParent walks to the container holding the last token of path and
+// returns the document with that container replaced by the result of fn.
+func updateParent(doc interface{}, path []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found: %s", path[0])
+		}
+
+		updated, err := updateParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[path[0]] = updated
+		return node, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := updateParent(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		node[i] = updated
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("path not found: %s", path[0])
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[key] = value
+			return node, nil
+		case []interface{}:
+			if key == "-" {
+				return append(node, value), nil
+			}
+
+			i, err := arrayIndex(key, len(node))
+			if err != nil {
+				return nil, err
+			}
+
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("cannot add %s to a non-container value", key)
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[key]; !ok {
+				return nil, fmt.Errorf("path not found: %s", key)
+			}
+
+			delete(node, key)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(node[:i], node[i+1:]...), nil
+		}
+
+		return nil, fmt.Errorf("path not found: %s", key)
+	})
+}
+
+func setValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updateParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[key] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+
+			node[i] = value
+			return node, nil
+		}
+
+		return nil, fmt.Errorf("path not found: %s", key)
+	})
+}
+
+// arrayIndex parses an array index token, which must be between 0 and max inclusive.
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index: %s", token)
+	}
+
+	return i, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		clone := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			clone[key] = deepCopy(child)
+		}
+
+		return clone
+	case []interface{}:
+		clone := make([]interface{}, len(v))
+		for i, child := range v {
+			clone[i] = deepCopy(child)
+		}
+
+		return clone
+	}
+
+	return value
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestApplyPatch tests the function ApplyPatch
+func TestApplyPatch(t *testing.T) {
+	id := primitive.NewObjectID()
+	person := Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}
+
+	tests := []struct {
+		Name   string
+		Patch  string
+		Result Person
+	}{
+		{
+			"replace",
+			`[{"op":"replace","path":"/firstname","value":"Jack"}]`,
+			Person{ID: id, Firstname: "Jack", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"replace_nested",
+			`[{"op":"replace","path":"/location/city","value":"Paris"}]`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "Paris", Country: "GB"}},
+		},
+		{
+			"remove",
+			`[{"op":"remove","path":"/location"}]`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith"},
+		},
+		{
+			"add_replaces_existing_member",
+			`[{"op":"add","path":"/location","value":{"city":"Oslo"}}]`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "Oslo"}},
+		},
+		{
+			"move",
+			`[{"op":"move","from":"/firstname","path":"/lastname"},{"op":"add","path":"/firstname","value":"Jack"}]`,
+			Person{ID: id, Firstname: "Jack", Lastname: "John", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"copy",
+			`[{"op":"copy","from":"/firstname","path":"/lastname"}]`,
+			Person{ID: id, Firstname: "John", Lastname: "John", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"test_then_replace",
+			`[{"op":"test","path":"/location","value":{"city":"London","country":"GB"}},{"op":"replace","path":"/lastname","value":"Jones"}]`,
+			Person{ID: id, Firstname: "John", Lastname: "Jones", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"id_cannot_be_changed",
+			`[{"op":"replace","path":"/id","value":"000000000000000000000000"}]`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"empty_patch",
+			`[]`,
+			person,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			var patch []Patch
+			assert.NoError(t, json.Unmarshal([]byte(tc.Patch), &patch))
+
+			actual, err := ApplyPatch(*person.Clone(), patch)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, *actual)
+		})
+	}
+}
+
+// TestApplyPatchErrors tests that invalid or failing patches return a PatchError.
+func TestApplyPatchErrors(t *testing.T) {
+	person := Person{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London"}}
+
+	tests := []struct {
+		Name  string
+		Patch string
+	}{
+		{"unknown_op", `[{"op":"rename","path":"/firstname"}]`},
+		{"invalid_pointer", `[{"op":"remove","path":"firstname"}]`},
+		{"missing_value", `[{"op":"add","path":"/firstname"}]`},
+		{"remove_missing", `[{"op":"remove","path":"/location/country"}]`},
+		{"replace_missing", `[{"op":"replace","path":"/location/country","value":"GB"}]`},
+		{"add_missing_parent", `[{"op":"add","path":"/address/street","value":"High St"}]`},
+		{"test_failed", `[{"op":"test","path":"/firstname","value":"Jack"}]`},
+		{"move_into_child", `[{"op":"move","from":"/location","path":"/location/city"}]`},
+		{"unknown_field", `[{"op":"add","path":"/nickname","value":"Johnny"}]`},
+		{"wrong_type", `[{"op":"replace","path":"/firstname","value":42}]`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			var patch []Patch
+			assert.NoError(t, json.Unmarshal([]byte(tc.Patch), &patch))
+
+			_, err := ApplyPatch(person, patch)
+			assert.IsType(t, &PatchError{}, err)
+		})
+	}
+
+	// A patch that leaves the person invalid returns a ValidationError.
+	_, err := ApplyPatch(person, []Patch{{Op: "remove", Path: "/lastname"}})
+	assert.IsType(t, &ValidationError{}, err)
+
+	// The original person is never modified.
+	assert.Equal(t, "London", person.Location.City)
+}
+
+// TestParsePointer tests the function parsePointer
+func TestParsePointer(t *testing.T) {
+	tokens, err := parsePointer("/a~1b/c~0d/0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/b", "c~d", "0"}, tokens)
+
+	tokens, err = parsePointer("")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed when checking the exp and nbf claims.
+const jwtLeeway = 30 * time.Second
+
+// A JWTVerifier checks the signature and claims of RFC 7519 JSON Web Tokens
+// signed with HS256 or RS256. Only the algorithms it has a key for are accepted,
+// so that an RS256 public key can never be used as an HS256 secret.
+type JWTVerifier struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	now       func() time.Time
+}
+
+// JWTClaims are the registered claims of a token used to identify the caller.
+type JWTClaims struct {
+	Subject   string   `json:"sub"`
+	Name      string   `json:"name,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt *int64   `json:"exp,omitempty"`
+	NotBefore *int64   `json:"nbf,omitempty"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+
+	*a = multiple
+	return nil
+}
+
+// NewJWTVerifier returns a verifier accepting HS256 tokens signed with secret,
+// if it is not empty, and RS256 tokens signed with the key of publicKeyPEM, if
+// it is not empty. If issuer or audience are set, tokens must carry them.
+func NewJWTVerifier(secret, publicKeyPEM []byte, issuer, audience string) (*JWTVerifier, error) {
+	verifier := &JWTVerifier{secret: secret, issuer: issuer, audience: audience, now: time.Now}
+	if len(publicKeyPEM) > 0 {
+		key, err := parseRSAPublicKey(publicKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		verifier.publicKey = key
+	}
+
+	return verifier, nil
+}
+
+// parseRSAPublicKey parses a PEM encoded PKIX or PKCS #1 RSA public key.
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// Verify checks the signature, expiry, issuer and audience of the token and
+// returns its claims.
+func (v *JWTVerifier) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && len(v.secret) > 0:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case header.Alg == "RS256" && v.publicKey != nil:
+		digest := sha256.Sum256(signed)
+		if err = rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("token algorithm %q is not accepted", header.Alg)
+	}
+
+	var claims JWTClaims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+
+	if err = v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (v *JWTVerifier) checkClaims(claims *JWTClaims) error {
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+
+	if now.Add(-jwtLeeway).After(time.Unix(*claims.ExpiresAt, 0)) {
+		return errors.New("token has expired")
+	}
+
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return errors.New("token has the wrong issuer")
+	}
+
+	if v.audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == v.audience {
+				return nil
+			}
+		}
+
+		return errors.New("token has the wrong audience")
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
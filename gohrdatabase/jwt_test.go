@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// signJWT returns a token with the claims, signed with an HS256 secret or an RS256 private key.
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newRSAKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// TestJWTVerifier tests the signature and claim checks of HS256 and RS256 tokens.
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, publicKeyPEM := newRSAKey(t)
+	otherKey, _ := newRSAKey(t)
+
+	verifier, err := NewJWTVerifier(testSecret, publicKeyPEM, "https://idp.example.com", "hrdatabase")
+	assert.NoError(t, err)
+
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "hrdatabase", "exp": now + 60}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+
+		return claims
+	}
+
+	tests := []struct {
+		Name  string
+		Token string
+		Valid bool
+	}{
+		{"hs256", signJWT(t, "HS256", testSecret, valid), true},
+		{"rs256", signJWT(t, "RS256", rsaKey, valid), true},
+		{"audience_list", signJWT(t, "HS256", testSecret, with("aud", []string{"other", "hrdatabase"})), true},
+		{"wrong_secret", signJWT(t, "HS256", []byte("another secret"), valid), false},
+		{"wrong_rsa_key", signJWT(t, "RS256", otherKey, valid), false},
+		{"public_key_as_secret", signJWT(t, "HS256", publicKeyPEM, valid), false},
+		{"none", signJWT(t, "none", nil, valid), false},
+		{"no_expiry", signJWT(t, "HS256", testSecret, with("exp", nil)), false},
+		{"expired", signJWT(t, "HS256", testSecret, with("exp", now-120)), false},
+		{"not_yet_valid", signJWT(t, "HS256", testSecret, with("nbf", now+120)), false},
+		{"no_subject", signJWT(t, "HS256", testSecret, with("sub", nil)), false},
+		{"wrong_issuer", signJWT(t, "HS256", testSecret, with("iss", "https://evil.example.com")), false},
+		{"wrong_audience", signJWT(t, "HS256", testSecret, with("aud", "payroll")), false},
+		{"malformed", "not.a.jwt", false},
+		{"two_parts", "abc.def", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			claims, err := verifier.Verify(tc.Token)
+			if tc.Valid {
+				assert.NoError(t, err)
+				assert.Equal(t, "alice", claims.Subject)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// TestJWTVerifierAlgorithms tests that only the algorithms with a configured key are accepted.
+func TestJWTVerifierAlgorithms(t *testing.T) {
+	rsaKey, _ := newRSAKey(t)
+	verifier, err := NewJWTVerifier(testSecret, nil, "", "")
+	assert.NoError(t, err)
+
+	_, err = verifier.Verify(signJWT(t, "RS256", rsaKey, map[string]interface{}{"sub": "alice"}))
+	assert.ErrorContains(t, err, `"RS256" is not accepted`)
+
+	_, err = NewJWTVerifier(nil, []byte("not a key"), "", "")
+	assert.Error(t, err)
+}
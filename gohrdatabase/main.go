@@ -1,15 +1,115 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	ConnectDatabase()
-	router := NewRouter()
-	fmt.Println("Listening on http://localhost:12345")
-	fmt.Println("Press 'CTRL + C' to stop server.")
-	log.Fatal(http.ListenAndServe(":12345", router))
-}
\ No newline at end of file
+	cfg, err := LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+
+		log.Fatal(err)
+	}
+
+	slog.SetDefault(cfg.NewLogger(os.Stderr))
+
+	auth, err := LoadAuthenticator(cfg)
+	if err != nil {
+		fatal("cannot load the authentication keys", err)
+	} else if auth == nil {
+		slog.Warn("auth is none, /person routes are open to everyone")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := ConnectDatabase(ctx, cfg)
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		store.Close(context.Background())
+		fatal("cannot listen", err)
+	}
+
+	slog.Info("listening", "addr", listener.Addr().String(), "storage", store.Mode(), "version", buildVersion())
+	if err = run(ctx, cfg, listener, NewRouter(store, cfg, auth), store); err != nil {
+		fatal("server stopped with an error", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// run serves HTTP requests on the listener, and purges deleted people in the
+// background, until ctx is done. It then stops accepting connections, waits up
+// to cfg.ShutdownTimeout for in-flight requests to finish, stops the purge and
+// closes the store.
+func run(ctx context.Context, cfg *Config, listener net.Listener, handler http.Handler, store PersonStore) error {
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		runPurge(purgeCtx, store, cfg.DeletedRetention, cfg.PurgeInterval)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// The server failed before being asked to stop.
+	case <-ctx.Done():
+		slog.Info("shutting down, waiting for in-flight requests", "timeout", cfg.ShutdownTimeout.String())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+
+		if err = server.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("shutting down server: %w", err)
+			server.Close()
+		} else {
+			err = <-serveErr
+		}
+
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	}
+
+	stopPurge()
+	<-purgeDone
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if closeErr := store.Close(closeCtx); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("closing store: %w", closeErr))
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// closeRecorder is a PersonStore that records whether it was closed.
+type closeRecorder struct {
+	*MemoryStore
+	closed chan struct{}
+}
+
+func (s *closeRecorder) Close(ctx context.Context) error {
+	close(s.closed)
+	return s.MemoryStore.Close(ctx)
+}
+
+// TestRunShutdown tests that run waits for in-flight requests to finish when it is
+// stopped, refuses new connections and closes the store.
+func TestRunShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	store := &closeRecorder{MemoryStore: NewMemoryStore(), closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, DefaultConfig(), listener, handler, store)
+	}()
+
+	url := "http://" + listener.Addr().String()
+	response := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get(url)
+		assert.NoError(t, err)
+		response <- res
+	}()
+
+	<-started
+	cancel()
+
+	res := <-response
+	if assert.NotNil(t, res) {
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		res.Body.Close()
+	}
+
+	assert.NoError(t, <-done)
+	select {
+	case <-store.closed:
+	default:
+		t.Error("store was not closed")
+	}
+
+	_, err = http.Get(url)
+	assert.Error(t, err)
+}
+
+// TestRunShutdownTimeout tests that run gives up on requests that outlast the shutdown timeout.
+func TestRunShutdownTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+
+	cfg := DefaultConfig()
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+	store := &closeRecorder{MemoryStore: NewMemoryStore(), closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, cfg, listener, handler, store)
+	}()
+
+	go func() {
+		if res, err := http.Get("http://" + listener.Addr().String()); err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+	<-store.closed
+}
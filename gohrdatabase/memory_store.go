@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A MemoryStore is a PersonStore that keeps person records in memory, keyed by
+// the hex form of their ObjectID. It is used when no MongoDB is available, and
+// is safe for concurrent use. As nothing blocks, the context is only checked
+// before each operation and while scanning the records.
+//
+// The audit events of each person are kept in memory too, and recorded under
+// the same lock as the change, so the history always matches the records.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	people map[string]Person
+	audit  map[string][]AuditEvent
+
+	// snapshotPath is the file the people are saved to when the store is closed.
+	snapshotPath string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{people: make(map[string]Person), audit: make(map[string][]AuditEvent)}
+}
+
+// A memorySnapshot is the content of a snapshot file. Older snapshot files are
+// a bare array of people, without an audit trail.
+type memorySnapshot struct {
+	People People       `json:"people"`
+	Audit  []AuditEvent `json:"audit"`
+}
+
+// OpenMemoryStore returns a MemoryStore that is saved to the snapshot file at
+// path when it is closed. If the file exists the store is loaded from it.
+func OpenMemoryStore(path string) (*MemoryStore, error) {
+	store := NewMemoryStore()
+	store.snapshotPath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshot memorySnapshot
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &snapshot.People)
+	} else {
+		err = json.Unmarshal(data, &snapshot)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+
+	for i := range snapshot.People {
+		migrateCountry(&snapshot.People[i])
+	}
+
+	if err = store.seed(snapshot.People); err != nil {
+		return nil, err
+	}
+
+	for _, event := range snapshot.Audit {
+		store.audit[event.PersonID.Hex()] = append(store.audit[event.PersonID.Hex()], event)
+	}
+
+	return store, nil
+}
+
+// Close saves the people and their audit events to the snapshot file, if the store has one.
+func (s *MemoryStore) Close(ctx context.Context) error {
+	if s.snapshotPath == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	snapshot := memorySnapshot{People: make(People, 0, len(s.people)), Audit: []AuditEvent{}}
+	for _, person := range s.people {
+		snapshot.People = append(snapshot.People, *person.Clone())
+	}
+
+	for _, id := range sortedKeys(s.audit) {
+		snapshot.Audit = append(snapshot.Audit, s.audit[id]...)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(snapshot.People, func(i, j int) bool {
+		return snapshot.People[i].ID.Hex() < snapshot.People[j].ID.Hex()
+	})
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.snapshotPath, data)
+}
+
+// Mode returns StorageMemory.
+func (s *MemoryStore) Mode() string {
+	return StorageMemory
+}
+
+// Ping returns the error of the context, as the in-memory store is always available.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// CreatePersonRecord assigns the person a new ObjectID and adds them to the in-memory map.
+func (s *MemoryStore) CreatePersonRecord(ctx context.Context, person Person) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.create(ctx, person)
+}
+
+// DeletePersonRecord marks the person in the in-memory map deleted.
+func (s *MemoryStore) DeletePersonRecord(ctx context.Context, id string, versions ...int64) (*mongo.DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.markDeleted(ctx, objectId, versions...); err != nil {
+		return nil, err
+	}
+
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+// RestorePersonRecord clears the deletion time of the person in the in-memory map.
+func (s *MemoryStore) RestorePersonRecord(ctx context.Context, id string) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.people[objectId.Hex()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if stored.DeletedAt == nil {
+		return nil, fmt.Errorf("%w: the person is not deleted", ErrConflict)
+	}
+
+	restored := stored.Clone()
+	restored.DeletedAt = nil
+	restored.Version++
+	event, err := newAuditEvent(ctx, AuditRestore, &stored, restored)
+	if err != nil {
+		return nil, err
+	}
+
+	s.people[objectId.Hex()] = *restored
+	s.record(event)
+	return restored.Clone(), nil
+}
+
+// PurgeDeletedPeople removes the people deleted before the given time from the
+// in-memory map, and the values of the fields from their audit events.
+func (s *MemoryStore) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, person := range s.people {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		if person.DeletedAt == nil || !person.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+
+		event, err := newPurgeEvent(ctx, &person)
+		if err != nil {
+			return purged, err
+		}
+
+		delete(s.people, id)
+		for i, earlier := range s.audit[id] {
+			s.audit[id][i].Changes = redactValues(earlier.Changes)
+		}
+
+		s.record(event)
+		purged++
+	}
+
+	return purged, nil
+}
+
+// CountPeople counts the person records in the in-memory map that match the query.
+func (s *MemoryStore) CountPeople(ctx context.Context, query bson.M) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, person := range s.people {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		matched, err := matchesFilter(person, query)
+		if err != nil {
+			return 0, err
+		}
+
+		if matched {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// GetAllPeople retrieves the person records from the in-memory map that match the query,
+// emulating the paging, sorting and projection MongoDB applies for opts.
+func (s *MemoryStore) GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) ([]*Person, error) {
+	result := []*Person{}
+	err := s.StreamPeople(ctx, query, opts, func(person *Person) error {
+		result = append(result, person)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// StreamPeople retrieves the person records from the in-memory map like
+// GetAllPeople. The matching records are copied and sorted under the read
+// lock, which is released before fn is called, and each is projected only as
+// fn is called with it.
+func (s *MemoryStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error {
+	if opts == nil {
+		opts = &FindOptions{}
+	}
+
+	docs, err := s.matchingDocuments(ctx, pagedQuery(query, opts))
+	if err != nil {
+		return err
+	}
+
+	sortDocuments(docs, opts.sortSpec())
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Offset >= int64(len(docs)) {
+		docs = nil
+	} else {
+		docs = docs[opts.Offset:]
+	}
+
+	if opts.Limit > 0 && opts.Limit < int64(len(docs)) {
+		docs = docs[:opts.Limit]
+	}
+
+	for _, doc := range docs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		person, err := fromDocument(projectDocument(doc, opts.Fields))
+		if err != nil {
+			return err
+		}
+
+		if err = fn(person); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPersonByObjectId retrieves the person from the in-memory map.
+func (s *MemoryStore) GetPersonByObjectId(ctx context.Context, id string) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	person, ok := s.people[objectId.Hex()]
+	if !ok || person.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	return person.Clone(), nil
+}
+
+// PatchPersonRecord applies the patch to the person in the in-memory map.
+func (s *MemoryStore) PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.people[objectId.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	// Apply works on a copy, so a failed patch leaves the record unchanged.
+	person, err := patch.Apply(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	person.Version = stored.Version + 1
+
+	event, err := newAuditEvent(ctx, AuditPatch, &stored, person)
+	if err != nil {
+		return nil, err
+	}
+
+	s.people[objectId.Hex()] = *person.Clone()
+	s.record(event)
+	return person, nil
+}
+
+// UpdatePersonRecord updates the person in the in-memory map.
+func (s *MemoryStore) UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return &Person{}, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return &Person{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.update(ctx, person, objectId, versions...)
+	if err != nil {
+		return &Person{}, err
+	}
+
+	return result, nil
+}
+
+// GetPersonHistory retrieves the audit events of the person from the in-memory log.
+func (s *MemoryStore) GetPersonHistory(ctx context.Context, id string) ([]AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := s.audit[objectId.Hex()]
+	if _, ok := s.people[objectId.Hex()]; !ok && len(events) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return append([]AuditEvent{}, events...), nil
+}
+
+// ApplyBatch applies the operations to the in-memory map in order, under a
+// single lock. An atomic batch is rolled back when an operation fails, by
+// restoring the records and audit logs it changed.
+func (s *MemoryStore) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollback := memoryRollback{people: make(map[string]*Person), audit: make(map[string]int)}
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i].Person, results[i].Err = s.apply(ctx, op, rollback)
+		if results[i].Err != nil && atomic {
+			rollback.restore(s)
+			abortBatch(results, i)
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// apply applies an operation of a batch, first saving the record and audit log
+// it changes to the rollback. The caller must hold the write lock.
+func (s *MemoryStore) apply(ctx context.Context, op BatchOperation, rollback memoryRollback) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if op.Op == BatchCreate {
+		person, err := s.create(ctx, *op.Person)
+		if err == nil {
+			rollback.save(s, person.ID.Hex(), nil)
+		}
+
+		return person, err
+	}
+
+	objectId, err := parseObjectID(op.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored, ok := s.people[objectId.Hex()]; ok {
+		rollback.save(s, objectId.Hex(), &stored)
+	}
+
+	switch op.Op {
+	case BatchUpdate:
+		return s.update(ctx, *op.Person.Clone(), objectId, op.versions()...)
+	case BatchDelete:
+		return s.markDeleted(ctx, objectId, op.versions()...)
+	default:
+		return nil, unknownBatchOperation(op)
+	}
+}
+
+// create assigns the person a new ObjectID and adds them to the in-memory map.
+// The caller must hold the write lock.
+func (s *MemoryStore) create(ctx context.Context, person Person) (*Person, error) {
+	person.ID = primitive.NewObjectID()
+	person.DeletedAt = nil
+	person.Version = 1
+	event, err := newAuditEvent(ctx, AuditCreate, nil, &person)
+	if err != nil {
+		return nil, err
+	}
+
+	s.people[person.ID.Hex()] = *person.Clone()
+	s.record(event)
+	return &person, nil
+}
+
+// update replaces the person in the in-memory map, if they are at one of the
+// versions when any are given. The caller must hold the write lock.
+func (s *MemoryStore) update(ctx context.Context, person Person, objectId primitive.ObjectID, versions ...int64) (*Person, error) {
+	stored, ok := s.people[objectId.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	if err := checkVersion(stored, versions...); err != nil {
+		return nil, err
+	}
+
+	person.ID = objectId
+	person.DeletedAt = nil
+	person.Version = stored.Version + 1
+	event, err := newAuditEvent(ctx, AuditUpdate, &stored, &person)
+	if err != nil {
+		return nil, err
+	}
+
+	s.people[objectId.Hex()] = *person.Clone()
+	s.record(event)
+	return &person, nil
+}
+
+// markDeleted sets the deletion time of the person in the in-memory map, if
+// they are at one of the versions when any are given, and returns them as
+// deleted. The caller must hold the write lock.
+func (s *MemoryStore) markDeleted(ctx context.Context, objectId primitive.ObjectID, versions ...int64) (*Person, error) {
+	stored, ok := s.people[objectId.Hex()]
+	if !ok || stored.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	if err := checkVersion(stored, versions...); err != nil {
+		return nil, err
+	}
+
+	deleted := stored.Clone()
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	deleted.DeletedAt = &deletedAt
+	deleted.Version++
+	event, err := newAuditEvent(ctx, AuditDelete, &stored, deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	s.people[objectId.Hex()] = *deleted
+	s.record(event)
+	return deleted.Clone(), nil
+}
+
+// A memoryRollback holds the records and audit logs changed by a batch as they
+// were before it, so that the batch can be undone.
+type memoryRollback struct {
+	// people holds the records by id, nil for the people the batch created.
+	people map[string]*Person
+	// audit holds the length of the audit log of each person.
+	audit map[string]int
+}
+
+// save remembers the record and audit log of the person with id, unless they
+// already are. A nil stored person is one the batch created. The caller must
+// hold the write lock.
+func (r memoryRollback) save(s *MemoryStore, id string, stored *Person) {
+	if _, ok := r.audit[id]; ok {
+		return
+	}
+
+	r.people[id] = nil
+	r.audit[id] = 0
+	if stored != nil {
+		r.people[id] = stored.Clone()
+		r.audit[id] = len(s.audit[id])
+	}
+}
+
+// restore puts the saved records and audit logs back. The caller must hold the write lock.
+func (r memoryRollback) restore(s *MemoryStore) {
+	for id, person := range r.people {
+		if person == nil {
+			delete(s.people, id)
+		} else {
+			s.people[id] = *person
+		}
+
+		if length := r.audit[id]; length == 0 {
+			delete(s.audit, id)
+		} else {
+			s.audit[id] = s.audit[id][:length]
+		}
+	}
+}
+
+// record appends the event to the audit log. The caller must hold the write lock.
+func (s *MemoryStore) record(event AuditEvent) {
+	id := event.PersonID.Hex()
+	s.audit[id] = append(s.audit[id], event)
+}
+
+// matchingDocuments returns the BSON documents of the people that match the query.
+func (s *MemoryStore) matchingDocuments(ctx context.Context, query bson.M) ([]bson.M, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	docs := make([]bson.M, 0, len(s.people))
+	for _, person := range s.people {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		matched, err := matchesFilter(person, query)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched {
+			continue
+		}
+
+		doc, err := toDocument(person)
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+func (s *MemoryStore) isEmpty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.people) == 0
+}
+
+func (s *MemoryStore) seed(people People) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, person := range people {
+		if person.ID.IsZero() {
+			person.ID = primitive.NewObjectID()
+		}
+
+		// People saved before versions were introduced are at version 0.
+		if person.Version == 0 {
+			person.Version = 1
+		}
+
+		s.people[person.ID.Hex()] = *person.Clone()
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file next to path and renames
+// it over path, so that a crash never leaves a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
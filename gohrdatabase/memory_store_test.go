@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMemoryStoreGetAllPeople tests that the in-memory store applies the filters from parseQuery.
+func TestMemoryStoreGetAllPeople(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{
+		{
+			Firstname:  "John",
+			Lastname:   "Smith",
+			Location:   &Location{City: "London", Country: "GB"},
+			Email:      "john.smith@example.com",
+			Employment: &Employment{Department: "Sales", StartDate: "2019-03-01", Status: EmploymentActive},
+		},
+		{
+			Firstname:  "Emma",
+			Lastname:   "Jones",
+			Location:   &Location{City: "Paris", Country: "FR"},
+			Employment: &Employment{Department: "Engineering", StartDate: "2021-06-14", EndDate: "2023-01-31", Status: EmploymentTerminated},
+		},
+		{Firstname: "Mia", Lastname: "Smith", Location: &Location{City: "Tokyo", Country: "JP"}},
+		{Firstname: "Ava", Lastname: "Brown"},
+	})
+
+	tests := []struct {
+		Query  string
+		Result int
+	}{
+		{"", 4},
+		{"city=London,Paris", 2},
+		{"lastname=Smith&country=JP", 1},
+		{"firstname=John&firstname=Ava", 2},
+		{"city=Berlin", 0},
+		{"lastname^=Sm", 2},
+		{"lastname^*=sm", 2},
+		{"lastname~=o", 2},
+		{"country!=GB", 3},
+		{"country!=GB,FR", 2},
+		{"city!~*=O", 2},
+		{"q=city=London OR firstname=Emma", 2},
+		{`q=NOT (lastname=Smith OR location.country="FR")`, 1},
+		{"q=lastname=Smith AND NOT city~*=lon&firstname^=M", 1},
+		{"department=Sales", 1},
+		{"employment.status!=terminated", 3},
+		{"start_date^=2021", 1},
+		{"q=status=terminated OR email~=@example.com", 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Query, func(t *testing.T) {
+			values, err := url.ParseQuery(tc.Query)
+			assert.NoError(t, err)
+
+			filter, err := parseQuery(values, getPeopleQueryFilter())
+			assert.NoError(t, err)
+
+			people, err := store.GetAllPeople(context.Background(), filter, nil)
+			assert.NoError(t, err)
+			assert.Len(t, people, tc.Result)
+		})
+	}
+}
+
+// TestMemoryStoreConcurrentRequests hammers every route concurrently, with
+// every worker reading and writing the same people. Run with -race to verify
+// the store is free of data races.
+func TestMemoryStoreConcurrentRequests(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(20))
+
+	var ids []string
+	for id := range store.people {
+		ids = append(ids, id)
+	}
+
+	// The workers share the first few people, so that they change and delete
+	// the same ones at the same time.
+	shared := ids[:5]
+
+	const workers = 4
+	const iterations = 25
+
+	var mu sync.Mutex
+	deleted := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := shared[i%len(shared)]
+				assert.Equal(t, http.StatusOK, serve(store, http.MethodGet, "/person?sort=-lastname", "").Code)
+				assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, serve(store, http.MethodGet, "/person/"+id, "").Code)
+				assert.Equal(t, http.StatusOK, serve(store, http.MethodPost, "/person", fmt.Sprintf(`{"firstname":"Worker%d","lastname":"Run%d"}`, w, i)).Code)
+				assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, serve(store, http.MethodPatch, "/person/"+id, `[{"op":"replace","path":"/firstname","value":"Patched"}]`).Code)
+				assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, serve(store, http.MethodPut, "/person/"+id, `{"firstname":"Updated","lastname":"Person","location":{"city":"Oslo","country":"NO"}}`).Code)
+
+				// Only the last iterations delete, so that the others race on live people.
+				if i < iterations-len(shared) {
+					continue
+				}
+
+				status := serve(store, http.MethodDelete, "/person/"+id, "").Code
+				assert.Contains(t, []int{http.StatusOK, http.StatusNotFound}, status)
+				if status == http.StatusOK {
+					mu.Lock()
+					deleted[id]++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	// Each shared person is deleted by exactly one worker.
+	for _, id := range shared {
+		assert.Equal(t, 1, deleted[id], id)
+
+		// Every change is given the next version, however the workers interleave.
+		events, err := store.GetPersonHistory(context.Background(), id)
+		assert.NoError(t, err)
+		for i, event := range events {
+			assert.Equal(t, int64(i+2), event.Version, id)
+		}
+	}
+
+	people, err := store.GetAllPeople(context.Background(), notDeleted(nil), nil)
+	assert.NoError(t, err)
+	assert.Len(t, people, len(ids)-len(shared)+workers*iterations)
+}
+
+// TestMemoryStoreRoundTrip tests that the id returned on create can be used with every other route.
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	store := NewMemoryStore()
+
+	rec := serve(store, http.MethodPost, "/person", `{"firstname":"John","lastname":"Smith"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var created Person
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.False(t, created.ID.IsZero())
+	id := created.ID.Hex()
+
+	rec = serve(store, http.MethodGet, "/person/"+id, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"`+id+`"`)
+
+	rec = serve(store, http.MethodGet, "/person?lastname=Smith", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"`+id+`"`)
+
+	rec = serve(store, http.MethodPut, "/person/"+id, `{"id":"000000000000000000000000","firstname":"Jack","lastname":"Smith"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"`+id+`"`)
+	assert.Contains(t, rec.Body.String(), `"firstname":"Jack"`)
+
+	rec = serve(store, http.MethodPatch, "/person/"+id, `[{"op":"replace","path":"/lastname","value":"Jones"},{"op":"add","path":"/location","value":{"city":"Oslo"}}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"id":"`+id+`"`)
+	assert.Contains(t, rec.Body.String(), `"lastname":"Jones"`)
+	assert.Contains(t, rec.Body.String(), `"city":"Oslo"`)
+
+	// A failing operation leaves the record untouched.
+	rec = serve(store, http.MethodPatch, "/person/"+id, `[{"op":"replace","path":"/firstname","value":"Ava"},{"op":"test","path":"/lastname","value":"Smith"}]`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serve(store, http.MethodGet, "/person/"+id, "")
+	assert.Contains(t, rec.Body.String(), `"firstname":"Jack"`)
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/person/"+id, strings.NewReader(`{"location":null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), `"location"`)
+
+	rec = serve(store, http.MethodDelete, "/person/"+id, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(store, http.MethodGet, "/person/"+id, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// TestMemoryStoreErrors tests that missing and invalid ids return the same status on every route.
+func TestMemoryStoreErrors(t *testing.T) {
+	store := NewMemoryStore()
+	missing := primitive.NewObjectID().Hex()
+
+	for _, tc := range []struct {
+		Method string
+		Body   string
+	}{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"firstname":"John","lastname":"Smith"}`},
+		{http.MethodPatch, `[{"op":"replace","path":"/firstname","value":"Jack"}]`},
+		{http.MethodDelete, ""},
+	} {
+		rec := serve(store, tc.Method, "/person/"+missing, tc.Body)
+		assert.Equal(t, http.StatusNotFound, rec.Code, tc.Method)
+
+		rec = serve(store, tc.Method, "/person/not-an-id", tc.Body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tc.Method)
+	}
+
+	_, err := store.DeletePersonRecord(context.Background(), missing)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = store.GetPersonByObjectId(context.Background(), "not-an-id")
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+// TestMemoryStoreFindOptions tests that the in-memory store pages, sorts and projects results.
+func TestMemoryStoreFindOptions(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{
+		{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+		{Firstname: "Emma", Lastname: "Jones", Location: &Location{City: "Paris", Country: "FR"}},
+		{Firstname: "Mia", Lastname: "Smith", Location: &Location{City: "Tokyo", Country: "JP"}},
+		{Firstname: "Ava", Lastname: "Brown"},
+	})
+
+	names := func(people []*Person) []string {
+		var result []string
+		for _, person := range people {
+			result = append(result, person.Firstname+" "+person.Lastname)
+		}
+
+		return result
+	}
+
+	people, err := store.GetAllPeople(context.Background(), nil, &FindOptions{Sort: []SortField{{Path: "lastname"}, {Path: "firstname", Descending: true}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Ava Brown", "Emma Jones", "Mia Smith", "John Smith"}, names(people))
+
+	people, err = store.GetAllPeople(context.Background(), nil, &FindOptions{Limit: 2, Offset: 1, Sort: []SortField{{Path: "firstname"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Emma Jones", "John Smith"}, names(people))
+
+	people, err = store.GetAllPeople(context.Background(), nil, &FindOptions{Offset: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, people)
+
+	// People without a location sort first, as they do in MongoDB.
+	people, err = store.GetAllPeople(context.Background(), nil, &FindOptions{Limit: 1, Sort: []SortField{{Path: "location.city"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Ava Brown"}, names(people))
+
+	people, err = store.GetAllPeople(context.Background(), nil, &FindOptions{Sort: []SortField{{Path: "firstname"}}, Fields: []string{"firstname", "location.city"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "Ava", people[0].Firstname)
+	assert.Nil(t, people[0].Location)
+	assert.Empty(t, people[1].Lastname)
+	assert.Equal(t, &Location{City: "Paris"}, people[1].Location)
+	assert.False(t, people[1].ID.IsZero())
+}
+
+// TestMemoryStoreSnapshot tests that a store opened from a snapshot file saves its people on close
+// and that a new store opened from the same file loads them again.
+func TestMemoryStoreSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "people.json")
+
+	store, err := OpenMemoryStore(path)
+	assert.NoError(t, err)
+	assert.True(t, store.isEmpty())
+
+	created, err := store.CreatePersonRecord(context.Background(), Person{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close(context.Background()))
+
+	reopened, err := OpenMemoryStore(path)
+	assert.NoError(t, err)
+
+	person, err := reopened.GetPersonByObjectId(context.Background(), created.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, created, person)
+
+	events, err := reopened.GetPersonHistory(context.Background(), created.ID.Hex())
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, AuditCreate, events[0].Action)
+	}
+
+	// Snapshots written before the audit trail are a bare array of people, and
+	// their legacy country names are migrated to codes.
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"id":"650000000000000000000000","firstname":"John","lastname":"Smith","location":{"city":"London","country":"UK"}}]`), 0o600))
+	legacy, err := OpenMemoryStore(path)
+	assert.NoError(t, err)
+	person, err = legacy.GetPersonByObjectId(context.Background(), "650000000000000000000000")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "GB", person.Location.Country)
+	}
+
+	patched, err := legacy.PatchPersonRecord(context.Background(), MergePatch(`{"firstname":"Jack"}`), "650000000000000000000000")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Jack", patched.Firstname)
+	}
+
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	_, err = OpenMemoryStore(path)
+	assert.Error(t, err)
+}
+
+// TestMemoryStoreContext tests that every method of the in-memory store returns
+// the error of a done context without touching the records.
+func TestMemoryStoreContext(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(10))
+	id := primitive.NewObjectID().Hex()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		Ctx context.Context
+		Err error
+	}{
+		{cancelled, context.Canceled},
+		{expired, context.DeadlineExceeded},
+	} {
+		_, err := store.CreatePersonRecord(tc.Ctx, Person{Firstname: "John", Lastname: "Smith"})
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.DeletePersonRecord(tc.Ctx, id)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.CountPeople(tc.Ctx, nil)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.GetAllPeople(tc.Ctx, nil, nil)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.GetPersonByObjectId(tc.Ctx, id)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.PatchPersonRecord(tc.Ctx, JSONPatch{}, id)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.UpdatePersonRecord(tc.Ctx, Person{Firstname: "John", Lastname: "Smith"}, id)
+		assert.ErrorIs(t, err, tc.Err)
+
+		_, err = store.GetPersonHistory(tc.Ctx, id)
+		assert.ErrorIs(t, err, tc.Err)
+	}
+
+	count, err := store.CountPeople(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), count)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A MergePatch is a RFC 7396 JSON Merge Patch document - https://datatracker.ietf.org/doc/html/rfc7396
+// Members of the patch replace those of the person, nested objects are merged
+// recursively and null values remove the member, e.g. {"location": null}.
+type MergePatch json.RawMessage
+
+// ValidateMergePatch checks that the merge patch is a JSON object, as any other
+// value would replace the whole person.
+func ValidateMergePatch(patch MergePatch) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(patch, &doc); err != nil || doc == nil {
+		return fmt.Errorf("merge patch must be a JSON object")
+	}
+
+	return nil
+}
+
+// Apply merges the patch into a copy of the person.
+func (p MergePatch) Apply(person Person) (*Person, error) {
+	if err := ValidateMergePatch(p); err != nil {
+		return nil, &PatchError{Index: -1, Err: err.Error()}
+	}
+
+	doc, err := toJSONDocument(person)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch interface{}
+	if err = json.Unmarshal(p, &patch); err != nil {
+		return nil, err
+	}
+
+	return fromJSONDocument(mergeValue(doc, patch), person)
+}
+
+// mergeValue implements the MergePatch algorithm from RFC 7396 section 2.
+func mergeValue(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergeValue(targetObject[key], value)
+		}
+	}
+
+	return targetObject
+}
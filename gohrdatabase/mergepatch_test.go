@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMergePatchApply tests the function MergePatch.Apply
+func TestMergePatchApply(t *testing.T) {
+	id := primitive.NewObjectID()
+	person := Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}
+
+	tests := []struct {
+		Name   string
+		Patch  string
+		Result Person
+	}{
+		{
+			"replace_member",
+			`{"firstname":"Jack"}`,
+			Person{ID: id, Firstname: "Jack", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+		},
+		{
+			"merge_nested_object",
+			`{"location":{"city":"Paris"}}`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "Paris", Country: "GB"}},
+		},
+		{
+			"null_removes_member",
+			`{"location":null}`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith"},
+		},
+		{
+			"null_removes_nested_member",
+			`{"location":{"country":null}}`,
+			Person{ID: id, Firstname: "John", Lastname: "Smith", Location: &Location{City: "London"}},
+		},
+		{
+			"id_cannot_be_changed",
+			`{"id":"000000000000000000000000"}`,
+			person,
+		},
+		{
+			"empty_patch",
+			`{}`,
+			person,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := MergePatch(tc.Patch).Apply(*person.Clone())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, *actual)
+		})
+	}
+}
+
+// TestMergePatchApplyErrors tests that invalid merge patches return a PatchError.
+func TestMergePatchApplyErrors(t *testing.T) {
+	person := Person{Firstname: "John", Lastname: "Smith"}
+
+	for _, patch := range []string{`null`, `"John"`, `[]`, `{"nickname":"Johnny"}`, `{"location":"London"}`} {
+		_, err := MergePatch(patch).Apply(person)
+		assert.IsType(t, &PatchError{}, err, patch)
+	}
+
+	_, err := MergePatch(`{"lastname":null}`).Apply(person)
+	assert.IsType(t, &ValidationError{}, err)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// durationBuckets are the upper bounds in seconds of the latency histograms,
+// the same as the Prometheus client defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects request and store metrics and serves them in the Prometheus
+// text exposition format - https://prometheus.io/docs/instrumenting/exposition_formats/
+// It is safe for concurrent use.
+type Metrics struct {
+	mu               sync.Mutex
+	inFlight         int64
+	requests         map[string]uint64
+	requestDurations map[string]*histogram
+	storeDurations   map[string]*histogram
+	storeErrors      map[string]uint64
+}
+
+// A histogram counts observations into durationBuckets.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:         make(map[string]uint64),
+		requestDurations: make(map[string]*histogram),
+		storeDurations:   make(map[string]*histogram),
+		storeErrors:      make(map[string]uint64),
+	}
+}
+
+// Middleware records the number, status codes and latency of the requests to
+// each route. Routes are identified by their path template, e.g. /person/{id},
+// so that the number of series does not grow with the number of people.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeTemplate(req)
+
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		defer func() {
+			m.observeRequest(req.Method, route, recorder.status, time.Since(start))
+		}()
+
+		next.ServeHTTP(recorder, req)
+	})
+}
+
+func (m *Metrics) observeRequest(method, route string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+	m.requests[labels("method", method, "route", route, "code", strconv.Itoa(status))]++
+	observe(m.requestDurations, labels("method", method, "route", route), duration)
+}
+
+// observeStore records the duration of a store operation, and the kind of error it failed with.
+func (m *Metrics) observeStore(operation string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	observe(m.storeDurations, labels("operation", operation), duration)
+	if err != nil {
+		m.storeErrors[labels("operation", operation, "error", errorKind(err))]++
+	}
+}
+
+// errorKind classifies a store error for the error counter.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrInvalidID):
+		return "invalid_id"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	case errors.Is(err, ErrPreconditionFailed):
+		return "precondition_failed"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		return "timeout"
+	}
+
+	return "internal"
+}
+
+func observe(histograms map[string]*histogram, key string, duration time.Duration) {
+	h, ok := histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		histograms[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += seconds
+	h.count++
+}
+
+// ServeHTTP handles GET /metrics, writing the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(out, "hrdatabase_build_info", "gauge", "Build information of the server, always 1.")
+	fmt.Fprintf(out, "hrdatabase_build_info{%s} 1\n", labels("version", buildVersion()))
+
+	writeHeader(out, "hrdatabase_http_requests_in_flight", "gauge", "Number of HTTP requests being served.")
+	fmt.Fprintf(out, "hrdatabase_http_requests_in_flight %d\n", m.inFlight)
+
+	writeHeader(out, "hrdatabase_http_requests_total", "counter", "Number of HTTP requests by method, route and status code.")
+	writeCounters(out, "hrdatabase_http_requests_total", m.requests)
+
+	writeHeader(out, "hrdatabase_http_request_duration_seconds", "histogram", "Latency of HTTP requests by method and route.")
+	writeHistograms(out, "hrdatabase_http_request_duration_seconds", m.requestDurations)
+
+	writeHeader(out, "hrdatabase_store_operation_duration_seconds", "histogram", "Latency of store operations.")
+	writeHistograms(out, "hrdatabase_store_operation_duration_seconds", m.storeDurations)
+
+	writeHeader(out, "hrdatabase_store_operation_errors_total", "counter", "Number of store operations that returned an error, by kind of error.")
+	writeCounters(out, "hrdatabase_store_operation_errors_total", m.storeErrors)
+}
+
+func writeHeader(out *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeCounters(out *bufio.Writer, name string, counters map[string]uint64) {
+	for _, key := range sortedKeys(counters) {
+		fmt.Fprintf(out, "%s{%s} %d\n", name, key, counters[key])
+	}
+}
+
+func writeHistograms(out *bufio.Writer, name string, histograms map[string]*histogram) {
+	for _, key := range sortedKeys(histograms) {
+		h := histograms[key]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(out, "%s_bucket{%s,le=\"%s\"} %d\n", name, key, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+
+		fmt.Fprintf(out, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key, h.count)
+		fmt.Fprintf(out, "%s_sum{%s} %s\n", name, key, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(out, "%s_count{%s} %d\n", name, key, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name and value pairs as a Prometheus label set, without the braces.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+
+	return strings.Join(parts, ",")
+}
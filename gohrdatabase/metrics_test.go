@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetrics tests that requests and store operations are exposed at /metrics by route.
+func TestMetrics(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(2))
+	router := NewRouter(store, DefaultConfig(), nil)
+
+	for _, target := range []string{"/person", "/person", "/person/650000000000000000000000", "/person/not-an-id"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE hrdatabase_http_requests_total counter",
+		`hrdatabase_http_requests_total{method="GET",route="/person",code="200"} 2`,
+		`hrdatabase_http_requests_total{method="GET",route="/person/{id}",code="404"} 1`,
+		`hrdatabase_http_requests_total{method="GET",route="/person/{id}",code="400"} 1`,
+		"# TYPE hrdatabase_http_request_duration_seconds histogram",
+		`hrdatabase_http_request_duration_seconds_bucket{method="GET",route="/person",le="+Inf"} 2`,
+		`hrdatabase_http_request_duration_seconds_count{method="GET",route="/person/{id}"} 2`,
+		`hrdatabase_store_operation_duration_seconds_count{operation="find"} 2`,
+		`hrdatabase_store_operation_duration_seconds_count{operation="count"} 2`,
+		`hrdatabase_store_operation_duration_seconds_count{operation="get"} 2`,
+		`hrdatabase_store_operation_errors_total{operation="get",error="not_found"} 1`,
+		`hrdatabase_store_operation_errors_total{operation="get",error="invalid_id"} 1`,
+		"hrdatabase_http_requests_in_flight 1",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+// TestMetricsForbidden tests that the store operations the policy forbids are
+// counted as forbidden errors.
+func TestMetricsForbidden(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(1))
+	router := NewRouter(store, DefaultConfig(), newTestAuthenticator(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/person", strings.NewReader(`{"firstname":"Jane","lastname":"Doe"}`))
+	req.Header.Set(APIKeyHeader, testViewerAPIKey)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `hrdatabase_store_operation_errors_total{operation="create",error="forbidden"} 1`+"\n")
+}
+
+// TestMetricsHistogram tests that observations are counted in every bucket they fit in.
+func TestMetricsHistogram(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeStore("get", 3*time.Millisecond, nil)
+	metrics.observeStore("get", 200*time.Millisecond, nil)
+	metrics.observeStore("get", time.Minute, nil)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	tests := []struct {
+		Bound string
+		Count int
+	}{
+		{"0.005", 1},
+		{"0.1", 1},
+		{"0.25", 2},
+		{"10", 2},
+		{"+Inf", 3},
+	}
+
+	for _, tc := range tests {
+		assert.Contains(t, body, `hrdatabase_store_operation_duration_seconds_bucket{operation="get",le="`+tc.Bound+`"} `+strconv.Itoa(tc.Count)+"\n")
+	}
+
+	assert.Contains(t, body, `hrdatabase_store_operation_duration_seconds_sum{operation="get"} 60.203`+"\n")
+}
+
+// TestLabels tests that label values are escaped.
+func TestLabels(t *testing.T) {
+	assert.Equal(t, `a="1",b="say \"hi\"\\\n"`, labels("a", "1", "b", "say \"hi\"\\\n"))
+}
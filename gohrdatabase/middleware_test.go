@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs sets the default logger to one writing debug JSON lines to the
+// returned buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+	})
+
+	return &buf
+}
+
+// logLines decodes the JSON log lines with the given message.
+func logLines(t *testing.T, buf *bytes.Buffer, msg string) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if line["msg"] == msg {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// TestRequestID tests that a valid X-Request-ID is propagated and that other requests are given one.
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Header string
+		Keep   bool
+	}{
+		{"missing", "", false},
+		{"valid", "abc-123_x.y:z", true},
+		{"invalid", "bad id\n", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/person/1", nil)
+			if tc.Header != "" {
+				req.Header.Set(RequestIDHeader, tc.Header)
+			}
+
+			rec := httptest.NewRecorder()
+			NewRouter(newFakeStore(), DefaultConfig(), nil).ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tc.Keep {
+				assert.Equal(t, tc.Header, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+		})
+	}
+}
+
+// TestRequestIDInProblem tests that error bodies carry the request ID, including for unknown routes.
+func TestRequestIDInProblem(t *testing.T) {
+	for _, tc := range []struct {
+		Method string
+		Target string
+		Status int
+	}{
+		{http.MethodGet, "/person/2", http.StatusNotFound},
+		{http.MethodGet, "/nowhere", http.StatusNotFound},
+		{http.MethodPost, "/person/1", http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.Method, tc.Target, nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		rec := httptest.NewRecorder()
+		NewRouter(newFakeStore(), DefaultConfig(), nil).ServeHTTP(rec, req)
+
+		var problem Problem
+		assert.Equal(t, tc.Status, rec.Code, tc.Target)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"), tc.Target)
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+		assert.Equal(t, "req-1", problem.RequestID, tc.Target)
+	}
+}
+
+// TestAccessLog tests that every request is logged once with its route, status
+// and size, and that store operations are logged with the request ID.
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	store := NewMemoryStore()
+	router := NewRouter(store, DefaultConfig(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/person/650000000000000000000000", nil)
+	req.Header.Set(RequestIDHeader, "req-2")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	requests := logLines(t, logs, "request")
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, "INFO", requests[0]["level"])
+		assert.Equal(t, "req-2", requests[0]["request_id"])
+		assert.Equal(t, "GET", requests[0]["method"])
+		assert.Equal(t, "/person/{id}", requests[0]["route"])
+		assert.Equal(t, "/person/650000000000000000000000", requests[0]["path"])
+		assert.Equal(t, float64(http.StatusNotFound), requests[0]["status"])
+		assert.Equal(t, float64(rec.Body.Len()), requests[0]["bytes"])
+		assert.Contains(t, requests[0], "latency_ms")
+
+		assert.Equal(t, "unmatched", requests[1]["route"])
+	}
+
+	operations := logLines(t, logs, "store operation")
+	if assert.Len(t, operations, 1) {
+		assert.Equal(t, "DEBUG", operations[0]["level"])
+		assert.Equal(t, "req-2", operations[0]["request_id"])
+		assert.Equal(t, "get", operations[0]["operation"])
+		assert.Equal(t, ErrNotFound.Error(), operations[0]["error"])
+	}
+}
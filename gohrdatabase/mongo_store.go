@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxChangeAttempts is how many times a change to a person is tried while other
+// changes to them keep getting in first.
+const maxChangeAttempts = 3
+
+// A MongoStore is a PersonStore backed by a MongoDB collection, with the audit
+// events of the changes to it in a second collection.
+type MongoStore struct {
+	collection *mongo.Collection
+	audit      *mongo.Collection
+}
+
+// NewMongoStore returns a MongoStore keeping people in collection and their audit events in audit.
+func NewMongoStore(collection, audit *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection, audit: audit}
+}
+
+// Close disconnects the client of the collection from MongoDB.
+func (s *MongoStore) Close(ctx context.Context) error {
+	return s.collection.Database().Client().Disconnect(ctx)
+}
+
+// Mode returns StorageMongo.
+func (s *MongoStore) Mode() string {
+	return StorageMongo
+}
+
+// Ping checks that the MongoDB server can be reached.
+func (s *MongoStore) Ping(ctx context.Context) error {
+	return isDatabaseConnected(ctx, s.collection.Database().Client())
+}
+
+// CreatePersonRecord assigns the person a new ObjectID and inserts them into the collection.
+func (s *MongoStore) CreatePersonRecord(ctx context.Context, person Person) (*Person, error) {
+	person.ID = primitive.NewObjectID()
+	person.DeletedAt = nil
+	person.Version = 1
+	_, err := s.collection.InsertOne(ctx, person)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &Person{}, fmt.Errorf("%w: %v", ErrConflict, err)
+		}
+
+		return &Person{}, err
+	}
+
+	s.record(ctx, AuditCreate, nil, &person)
+	return &person, nil
+}
+
+// DeletePersonRecord sets the deletion time of the person record in the collection.
+func (s *MongoStore) DeletePersonRecord(ctx context.Context, id string, versions ...int64) (*mongo.DeleteResult, error) {
+	if _, err := s.markDeleted(ctx, id, versions...); err != nil {
+		return nil, err
+	}
+
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+// markDeleted sets the deletion time of the person record in the collection,
+// if it is at one of the versions when any are given, and returns the person
+// as deleted.
+func (s *MongoStore) markDeleted(ctx context.Context, id string, versions ...int64) (*Person, error) {
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objectId}
+	if len(versions) > 0 {
+		filter["version"] = bson.M{"$in": versions}
+	}
+
+	var deleted Person
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt}, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.collection.FindOneAndUpdate(ctx, notDeleted(filter), update, opts).Decode(&deleted)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		} else if len(versions) == 0 {
+			return nil, ErrNotFound
+		}
+
+		// Either there is no such person, or they are at another version.
+		stored, err := s.GetPersonByObjectId(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = checkVersion(*stored, versions...); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: the person is being changed concurrently", ErrConflict)
+	}
+
+	stored := deleted.Clone()
+	stored.DeletedAt = nil
+	stored.Version--
+	s.record(ctx, AuditDelete, stored, &deleted)
+	return &deleted, nil
+}
+
+// RestorePersonRecord removes the deletion time of the person record in the collection.
+func (s *MongoStore) RestorePersonRecord(ctx context.Context, id string) (*Person, error) {
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored Person
+	filter := bson.M{"_id": objectId, "deleted_at": bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}}
+	if err = s.collection.FindOneAndUpdate(ctx, filter, update).Decode(&stored); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		// Either there is no such person, or they are not deleted.
+		if _, err = s.GetPersonByObjectId(ctx, id); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: the person is not deleted", ErrConflict)
+	}
+
+	restored := stored.Clone()
+	restored.DeletedAt = nil
+	restored.Version++
+	s.record(ctx, AuditRestore, &stored, restored)
+	return restored, nil
+}
+
+// PurgeDeletedPeople removes the person records deleted before the given time
+// from the collection, and the values of the fields from their audit events.
+func (s *MongoStore) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": deletedBefore}}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+
+	if err = cursor.All(ctx, &ids); err != nil {
+		return 0, err
+	}
+
+	// Each record is removed on its own, so that one restored in the meantime is kept.
+	var purged int64
+	for _, id := range ids {
+		var stored Person
+		filter = bson.M{"_id": id.ID, "deleted_at": bson.M{"$lt": deletedBefore}}
+		err = s.collection.FindOneAndDelete(ctx, filter).Decode(&stored)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		} else if err != nil {
+			return purged, err
+		}
+
+		s.recordPurge(ctx, &stored)
+		purged++
+	}
+
+	return purged, nil
+}
+
+// CountPeople counts the documents in the collection that match the query.
+func (s *MongoStore) CountPeople(ctx context.Context, query bson.M) (int64, error) {
+	return s.collection.CountDocuments(ctx, query)
+}
+
+// GetAllPeople queries the collection for matching records.
+func (s *MongoStore) GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) ([]*Person, error) {
+	result := []*Person{}
+	err := s.StreamPeople(ctx, query, opts, func(person *Person) error {
+		result = append(result, person)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// StreamPeople queries the collection for matching records, and decodes them
+// one by one as the cursor returns them.
+func (s *MongoStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error {
+	cursor, err := s.collection.Find(ctx, pagedQuery(query, opts), findOptions(opts))
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var person Person
+		if err = cursor.Decode(&person); err != nil {
+			return err
+		}
+
+		if err = fn(&person); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// GetPersonByObjectId queries the collection for the person record.
+func (s *MongoStore) GetPersonByObjectId(ctx context.Context, id string) (*Person, error) {
+	var person *Person
+	docId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.collection.FindOne(ctx, notDeleted(bson.M{"_id": docId})).Decode(&person)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return person, nil
+}
+
+// PatchPersonRecord applies the patch to the stored person and replaces the document.
+// The patch is applied in full before anything is written, so a failing operation
+// leaves the document unchanged.
+func (s *MongoStore) PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (*Person, error) {
+	return s.change(ctx, id, AuditPatch, patch.Apply)
+}
+
+// UpdatePersonRecord replaces the person record in the collection.
+func (s *MongoStore) UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (*Person, error) {
+	result, err := s.change(ctx, id, AuditUpdate, func(stored Person) (*Person, error) {
+		if err := checkVersion(stored, versions...); err != nil {
+			return nil, err
+		}
+
+		return person.Clone(), nil
+	})
+
+	if err != nil {
+		return &Person{}, err
+	}
+
+	return result, nil
+}
+
+// GetPersonHistory queries the audit collection for the events of the person.
+func (s *MongoStore) GetPersonHistory(ctx context.Context, id string) ([]AuditEvent, error) {
+	objectId, err := parseObjectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.audit.Find(ctx, bson.M{"person_id": objectId}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AuditEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		// A person without history exists only if they were never changed.
+		if _, err = s.GetPersonByObjectId(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+// errBatchFailed aborts the transaction of an atomic batch when an operation fails.
+var errBatchFailed = errors.New("an operation of the batch failed")
+
+// ApplyBatch applies the operations to the collection. The people of the
+// creates are inserted together with InsertMany, and then the updates and
+// deletes are applied in order. An atomic batch is applied in a transaction,
+// which needs MongoDB to run as a replica set.
+func (s *MongoStore) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	if !atomic {
+		return s.applyBatch(ctx, ops, false), nil
+	}
+
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	// The transaction is retried on transient errors, each time with new results.
+	var results []BatchResult
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		results = s.applyBatch(ctx, ops, true)
+		for _, result := range results {
+			if result.Err != nil {
+				return nil, errBatchFailed
+			}
+		}
+
+		return nil, nil
+	})
+
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// applyBatch applies the operations in order, stopping at the first that fails
+// if atomic. Each run of consecutive creates is inserted with createMany.
+func (s *MongoStore) applyBatch(ctx context.Context, ops []BatchOperation, atomic bool) []BatchResult {
+	results := make([]BatchResult, len(ops))
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+		switch op.Op {
+		case BatchCreate:
+			end := i + 1
+			for end < len(ops) && ops[end].Op == BatchCreate {
+				end++
+			}
+
+			if failed := s.createMany(ctx, ops[i:end], results[i:end], atomic); failed >= 0 && atomic {
+				abortBatch(results, i+failed)
+				return results
+			}
+
+			i = end - 1
+			continue
+		case BatchUpdate:
+			results[i].Person, results[i].Err = s.UpdatePersonRecord(ctx, *op.Person, op.ID, op.versions()...)
+		case BatchDelete:
+			results[i].Person, results[i].Err = s.markDeleted(ctx, op.ID, op.versions()...)
+		default:
+			results[i].Err = unknownBatchOperation(op)
+		}
+
+		if results[i].Err != nil && atomic {
+			abortBatch(results, i)
+			return results
+		}
+	}
+
+	return results
+}
+
+// createMany inserts the people of the create operations with a single
+// InsertMany, and sets their results. An ordered insert stops at the first
+// person that fails. It returns the index of the first operation that failed,
+// or -1 if none did.
+func (s *MongoStore) createMany(ctx context.Context, ops []BatchOperation, results []BatchResult, ordered bool) int {
+	var indexes []int
+	var docs []interface{}
+	for i, op := range ops {
+		if op.Op != BatchCreate {
+			continue
+		}
+
+		person := op.Person.Clone()
+		person.ID = primitive.NewObjectID()
+		person.DeletedAt = nil
+		person.Version = 1
+		results[i].Person = person
+		indexes = append(indexes, i)
+		docs = append(docs, person)
+	}
+
+	if len(docs) == 0 {
+		return -1
+	}
+
+	// The errors of the people that were not inserted, by their index in docs.
+	failures := make(map[int]error)
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, writeErr := range bulkErr.WriteErrors {
+			failures[writeErr.Index] = writeErr
+			if mongo.IsDuplicateKeyError(writeErr) {
+				failures[writeErr.Index] = fmt.Errorf("%w: %v", ErrConflict, writeErr)
+			}
+		}
+
+		if ordered {
+			for i := bulkErr.WriteErrors[0].Index + 1; i < len(docs); i++ {
+				failures[i] = ErrBatchAborted
+			}
+		}
+	} else if err != nil {
+		for i := range docs {
+			failures[i] = err
+		}
+	}
+
+	failed := -1
+	var created []*Person
+	for i, index := range indexes {
+		if err, ok := failures[i]; ok {
+			results[index] = BatchResult{Err: err}
+			if failed < 0 {
+				failed = index
+			}
+
+			continue
+		}
+
+		created = append(created, results[index].Person)
+	}
+
+	s.recordCreated(ctx, created)
+	return failed
+}
+
+// change replaces the document of the person with the result of apply to the
+// stored person, at the next version. The document is only replaced if it is
+// still at the version apply was given, so a concurrent change is never
+// overwritten: apply is retried on the new version instead, up to
+// maxChangeAttempts times.
+func (s *MongoStore) change(ctx context.Context, id string, action string, apply func(stored Person) (*Person, error)) (*Person, error) {
+	for attempt := 1; ; attempt++ {
+		stored, err := s.GetPersonByObjectId(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		person, err := apply(*stored)
+		if err != nil {
+			return nil, err
+		}
+
+		// The id, deletion time and version in the request body, if any, are never written.
+		person.ID = stored.ID
+		person.DeletedAt = nil
+		person.Version = stored.Version + 1
+
+		filter := notDeleted(bson.M{"_id": stored.ID, "version": stored.Version})
+		result, err := s.collection.ReplaceOne(ctx, filter, person)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.MatchedCount == 1 {
+			s.record(ctx, action, stored, person)
+			return person, nil
+		}
+
+		if attempt == maxChangeAttempts {
+			return nil, fmt.Errorf("%w: the person is being changed concurrently", ErrConflict)
+		}
+	}
+}
+
+// record inserts the audit event of a change that has been written. The change
+// cannot be undone at this point, so a failure is logged rather than returned,
+// and the event is recorded even if the request has since been cancelled.
+func (s *MongoStore) record(ctx context.Context, action string, before, after *Person) {
+	event, err := newAuditEvent(ctx, action, before, after)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditWriteTimeout)
+		defer cancel()
+
+		_, err = s.audit.InsertOne(ctx, event)
+	}
+
+	if err != nil {
+		Logger(ctx).Error("cannot record audit event", "action", action, "person_id", event.PersonID.Hex(), "error", err.Error())
+	}
+}
+
+// recordPurge removes the values of the fields from the audit events of the
+// purged person, and records the purge. As with record, a failure is logged
+// rather than returned.
+func (s *MongoStore) recordPurge(ctx context.Context, person *Person) {
+	event, err := newPurgeEvent(ctx, person)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditWriteTimeout)
+		defer cancel()
+
+		redact := bson.M{"$set": bson.M{"changes.$[].before": nil, "changes.$[].after": nil}}
+		if _, err = s.audit.UpdateMany(ctx, bson.M{"person_id": person.ID}, redact); err == nil {
+			_, err = s.audit.InsertOne(ctx, event)
+		}
+	}
+
+	if err != nil {
+		Logger(ctx).Error("cannot record audit event", "action", AuditPurge, "person_id", person.ID.Hex(), "error", err.Error())
+	}
+}
+
+// recordCreated inserts the audit events of the creation of the people with a
+// single InsertMany. As with record, a failure is logged rather than returned.
+func (s *MongoStore) recordCreated(ctx context.Context, people []*Person) {
+	var events []interface{}
+	for _, person := range people {
+		event, err := newAuditEvent(ctx, AuditCreate, nil, person)
+		if err != nil {
+			Logger(ctx).Error("cannot record audit event", "action", AuditCreate, "person_id", person.ID.Hex(), "error", err.Error())
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditWriteTimeout)
+	defer cancel()
+
+	if _, err := s.audit.InsertMany(ctx, events); err != nil {
+		Logger(ctx).Error("cannot record audit events", "action", AuditCreate, "count", len(events), "error", err.Error())
+	}
+}
+
+// migrateVersions sets the version of the people written before versions were
+// introduced to 1, and returns how many it changed.
+func (s *MongoStore) migrateVersions(ctx context.Context) (int64, error) {
+	result, err := s.collection.UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// migrateCountries replaces the legacy country names of the people stored
+// before countries were validated with their ISO 3166-1 alpha-2 codes, as
+// people holding them could no longer be changed.
+func (s *MongoStore) migrateCountries(ctx context.Context) (int64, error) {
+	var migrated int64
+	for name, code := range legacyCountryNames {
+		result, err := s.collection.UpdateMany(ctx, bson.M{"location.country": name}, bson.M{"$set": bson.M{"location.country": code}})
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated += result.ModifiedCount
+	}
+
+	return migrated, nil
+}
+
+// ensureIndexes creates the index the history of a person is read with, if it does not exist.
+func (s *MongoStore) ensureIndexes(ctx context.Context) error {
+	_, err := s.audit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "person_id", Value: 1}, {Key: "timestamp", Value: 1}},
+	})
+
+	return err
+}
+
+func (s *MongoStore) isEmpty(ctx context.Context) (bool, error) {
+	count, err := s.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return false, fmt.Errorf("error counting documents in collection: %v", err)
+	}
+
+	return count == 0, nil
+}
+
+func (s *MongoStore) seed(ctx context.Context, people People) error {
+	for i := range people {
+		if people[i].ID.IsZero() {
+			people[i].ID = primitive.NewObjectID()
+		}
+
+		people[i].Version = 1
+	}
+
+	_, err := s.collection.InsertMany(ctx, people.ConvertToInterface())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// findOptions translates the FindOptions into MongoDB find options.
+func findOptions(opts *FindOptions) *options.FindOptions {
+	result := options.Find()
+	if opts == nil {
+		return result
+	}
+
+	sort := bson.D{}
+	for _, field := range opts.sortSpec() {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+
+		sort = append(sort, bson.E{Key: field.Path, Value: direction})
+	}
+
+	result.SetSort(sort)
+	result.SetSkip(opts.Offset)
+	if opts.Limit > 0 {
+		result.SetLimit(opts.Limit)
+	}
+
+	if len(opts.Fields) > 0 {
+		projection := bson.M{}
+		for _, path := range opts.Fields {
+			projection[path] = 1
+		}
+
+		result.SetProjection(projection)
+	}
+
+	return result
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newUnreachableMongoStore returns a MongoStore for a server that never answers,
+// so that every operation waits until its context is done.
+func newUnreachableMongoStore(t *testing.T) *MongoStore {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(time.Minute))
+	assert.NoError(t, err)
+
+	database := client.Database("hrdatabase")
+	store := NewMongoStore(database.Collection("people"), database.Collection("people_audit"))
+	t.Cleanup(func() {
+		store.Close(context.Background())
+	})
+
+	return store
+}
+
+// TestMongoStoreContext tests that MongoDB operations stop when their context
+// is cancelled or times out, rather than waiting for the server.
+func TestMongoStoreContext(t *testing.T) {
+	store := newUnreachableMongoStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := store.GetAllPeople(ctx, nil, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = store.CountPeople(ctx, nil)
+	assert.True(t, mongo.IsTimeout(err), err)
+
+	_, err = store.GetPersonHistory(ctx, "650000000000000000000000")
+	assert.True(t, mongo.IsTimeout(err), err)
+}
+
+// TestMongoStoreTimeout tests that a request to an unresponsive MongoDB fails
+// with 504 once the store timeout passes.
+func TestMongoStoreTimeout(t *testing.T) {
+	store := newUnreachableMongoStore(t)
+	cfg := DefaultConfig()
+	cfg.StoreTimeout = 20 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	NewRouter(store, cfg, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/person/650000000000000000000000", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
+
+// TestMongoStoreBatchOrder tests that MongoDB applies the operations of a batch
+// in order, inserting each run of creates together, with the same results as
+// the in-memory store.
+func TestMongoStoreBatchOrder(t *testing.T) {
+	memory := NewMemoryStore()
+	memory.seed(People{{Firstname: "John", Lastname: "Smith"}})
+	people, err := memory.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	john := people[0]
+
+	jane := &Person{Firstname: "Jane", Lastname: "Doe"}
+	ops := []BatchOperation{
+		{Op: BatchDelete, ID: john.ID.Hex()},
+		{Op: BatchCreate, Person: jane},
+		{Op: BatchCreate, Person: jane},
+		{Op: BatchDelete, ID: "650000000000000000000000"},
+		{Op: BatchCreate, Person: jane},
+	}
+
+	want, err := memory.ApplyBatch(context.Background(), ops, false)
+	assert.NoError(t, err)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("batch", func(mt *mtest.T) {
+		deleted := bson.D{
+			{Key: "_id", Value: john.ID},
+			{Key: "firstname", Value: "John"},
+			{Key: "lastname", Value: "Smith"},
+			{Key: "version", Value: 2},
+			{Key: "deleted_at", Value: time.Now()},
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: deleted}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		store := NewMongoStore(mt.Coll, mt.DB.Collection("people_audit"))
+		results := store.applyBatch(context.Background(), ops, false)
+		if assert.Len(t, results, len(want)) {
+			for i := range want {
+				assert.Equal(t, want[i].Err, results[i].Err, i)
+				assert.Equal(t, want[i].Person == nil, results[i].Person == nil, i)
+			}
+		}
+
+		// The commands are sent in the order of the operations, each followed
+		// by the audit insert.
+		var commands []string
+		for _, started := range mt.GetAllStartedEvents() {
+			collection, _ := started.Command.Lookup(started.CommandName).StringValueOK()
+			commands = append(commands, started.CommandName+" "+collection)
+		}
+
+		assert.Equal(t, []string{
+			"findAndModify " + mt.Coll.Name(),
+			"insert people_audit",
+			"insert " + mt.Coll.Name(),
+			"insert people_audit",
+			"findAndModify " + mt.Coll.Name(),
+			"insert " + mt.Coll.Name(),
+			"insert people_audit",
+		}, commands)
+	})
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// FindOptions controls the paging, ordering and fields of the people returned by GetAllPeople.
+type FindOptions struct {
+	Limit  int64
+	Offset int64
+	// After continues a sort after the person a page token was made from, see
+	// pagedQuery. It is nil for the first page.
+	After *pageToken
+	Sort  []SortField
+	// Fields lists the BSON paths to return, or all fields when empty. The id is always returned.
+	Fields []string
+}
+
+// A SortField orders results by a BSON path, ascending unless Descending is set.
+type SortField struct {
+	Path       string
+	Descending bool
+}
+
+// pageToken is the decoded form of the opaque page_token query parameter: the
+// sort of the page the token was made from, as sortNames returns it, and the
+// sort key of the last person of that page, with nil for a missing value.
+// The next page starts after that person, wherever they now are, so that
+// people created or deleted in the meantime do not shift it.
+type pageToken struct {
+	Sort []string      `json:"s"`
+	Key  []interface{} `json:"k"`
+}
+
+// getPeopleFields returns the fields of a Person that can be used to sort and
+// project results, mapped from their JSON path to their BSON path.
+func getPeopleFields() map[string]string {
+	fields := map[string]string{
+		"id":         "_id",
+		"location":   "location",
+		"employment": "employment",
+	}
+
+	for _, queryFilter := range getPeopleQueryFilter() {
+		path := queryFilter.Name
+		if queryFilter.ParentPath != "" {
+			path = queryFilter.ParentPath + "." + path
+		}
+
+		fields[path] = path
+	}
+
+	return fields
+}
+
+// getSortFields returns the fields of getPeopleFields that people can be sorted
+// by, which are all of them but the embedded documents, as a page token could
+// not continue their order.
+func getSortFields() map[string]string {
+	fields := getPeopleFields()
+	delete(fields, "location")
+	delete(fields, "employment")
+	return fields
+}
+
+// parseFindOptions parses the limit, offset, page_token, sort and fields query
+// parameters. A page token must have been made for the same sort.
+func parseFindOptions(queryValues url.Values) (*FindOptions, error) {
+	opts := &FindOptions{Limit: defaultPageSize}
+	fields := getPeopleFields()
+	sortFields := getSortFields()
+
+	if limit := queryValues.Get("limit"); limit != "" {
+		value, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || value < 1 || value > maxPageSize {
+			return nil, fmt.Errorf("limit must be a number between 1 and %d", maxPageSize)
+		}
+
+		opts.Limit = value
+	}
+
+	offset, token := queryValues.Get("offset"), queryValues.Get("page_token")
+	if offset != "" && token != "" {
+		return nil, fmt.Errorf("offset and page_token cannot be used together")
+	}
+
+	if offset != "" {
+		value, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("offset must be a positive number")
+		}
+
+		opts.Offset = value
+	}
+
+	for _, name := range splitList(queryValues["sort"]) {
+		descending := strings.HasPrefix(name, "-")
+		path, ok := sortFields[strings.TrimPrefix(name, "-")]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", name)
+		}
+
+		opts.Sort = append(opts.Sort, SortField{Path: path, Descending: descending})
+	}
+
+	for _, name := range splitList(queryValues["fields"]) {
+		path, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+
+		opts.Fields = append(opts.Fields, path)
+	}
+
+	if token != "" {
+		after, err := decodePageToken(token)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Equal(after.Sort, opts.sortNames()) {
+			return nil, fmt.Errorf("page_token was made for another sort")
+		}
+
+		opts.After = after
+	}
+
+	return opts, nil
+}
+
+// splitList splits repeated and comma separated query values into a single list.
+func splitList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+
+	return result
+}
+
+// sortSpec returns the sort fields with the id appended as a tie-breaker, so
+// that pages are stable when sorting on fields with duplicate values.
+func (o *FindOptions) sortSpec() []SortField {
+	for _, field := range o.Sort {
+		if field.Path == "_id" {
+			return o.Sort
+		}
+	}
+
+	return append(append([]SortField{}, o.Sort...), SortField{Path: "_id"})
+}
+
+// sortNames returns the sort of sortSpec as the names of its paths, with a
+// leading "-" when descending.
+func (o *FindOptions) sortNames() []string {
+	var names []string
+	for _, field := range o.sortSpec() {
+		if field.Descending {
+			names = append(names, "-"+field.Path)
+		} else {
+			names = append(names, field.Path)
+		}
+	}
+
+	return names
+}
+
+// newPageToken returns the token of the page after the person, who must have
+// the fields the options sort by.
+func newPageToken(opts *FindOptions, person *Person) (string, error) {
+	doc, err := toDocument(*person)
+	if err != nil {
+		return "", err
+	}
+
+	token := pageToken{Sort: opts.sortNames()}
+	for _, field := range opts.sortSpec() {
+		value, _ := lookupPath(doc, field.Path)
+		token.Key = append(token.Key, value)
+	}
+
+	return encodePageToken(token), nil
+}
+
+func encodePageToken(token pageToken) string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken decodes the page token, checking that its key has a string
+// or nil for each field of its sort, and an ObjectID for the id, so that
+// pagedQuery always has a later id to continue with.
+func decodePageToken(value string) (*pageToken, error) {
+	var token pageToken
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &token)
+	}
+
+	if err != nil || len(token.Sort) == 0 || len(token.Sort) != len(token.Key) {
+		return nil, fmt.Errorf("invalid page_token")
+	}
+
+	for i, name := range token.Sort {
+		isID := strings.TrimPrefix(name, "-") == "_id"
+		switch value := token.Key[i].(type) {
+		case nil:
+			if isID {
+				return nil, fmt.Errorf("invalid page_token")
+			}
+		case string:
+			if !isID {
+				continue
+			}
+
+			if token.Key[i], err = primitive.ObjectIDFromHex(value); err != nil {
+				return nil, fmt.Errorf("invalid page_token")
+			}
+		default:
+			return nil, fmt.Errorf("invalid page_token")
+		}
+	}
+
+	return &token, nil
+}
+
+// pagedQuery returns the query restricted to the people after opts.After in
+// the sort of its page token, or the query itself if there is none.
+//
+// A person comes after the key if, for some field of the sort, they have the
+// same values as the key for the fields before it and a later value for it.
+// Missing values sort before any value, as they do in MongoDB.
+func pagedQuery(query bson.M, opts *FindOptions) bson.M {
+	if opts == nil || opts.After == nil {
+		return query
+	}
+
+	var branches []bson.M
+	var equal []bson.M
+	for i, name := range opts.After.Sort {
+		path, descending := strings.TrimPrefix(name, "-"), strings.HasPrefix(name, "-")
+		value := opts.After.Key[i]
+
+		var later bson.M
+		switch {
+		case value == nil && !descending:
+			later = bson.M{path: bson.M{"$exists": true}}
+		case value == nil:
+			// Nothing sorts before a missing value.
+		case !descending:
+			later = bson.M{path: bson.M{"$gt": value}}
+		default:
+			later = bson.M{"$or": []bson.M{{path: bson.M{"$lt": value}}, {path: bson.M{"$exists": false}}}}
+		}
+
+		if later != nil {
+			branches = append(branches, bson.M{"$and": append(append([]bson.M{}, equal...), later)})
+		}
+
+		if value == nil {
+			equal = append(equal, bson.M{path: bson.M{"$exists": false}})
+		} else {
+			equal = append(equal, bson.M{path: value})
+		}
+	}
+
+	after := bson.M{"$or": branches}
+	if len(query) == 0 {
+		return after
+	}
+
+	return bson.M{"$and": []bson.M{query, after}}
+}
+
+// sortDocuments orders documents using the same rules as MongoDB for the
+// values stored on a Person, where missing fields sort before any value.
+func sortDocuments(docs []bson.M, fields []SortField) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			a, aFound := lookupPath(docs[i], field.Path)
+			b, bFound := lookupPath(docs[j], field.Path)
+
+			c := compareValues(a, aFound, b, bFound)
+			if c == 0 {
+				continue
+			}
+
+			if field.Descending {
+				return c > 0
+			}
+
+			return c < 0
+		}
+
+		return false
+	})
+}
+
+func compareValues(a interface{}, aFound bool, b interface{}, bFound bool) int {
+	switch {
+	case !aFound && !bFound:
+		return 0
+	case !aFound:
+		return -1
+	case !bFound:
+		return 1
+	}
+
+	if aID, ok := a.(primitive.ObjectID); ok {
+		if bID, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(aID.Hex(), bID.Hex())
+		}
+	}
+
+	// Embedded documents compare by their string form, which is enough to
+	// group equal locations together.
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// projectDocument returns a copy of the document containing only the given
+// paths and the id.
+func projectDocument(doc bson.M, paths []string) bson.M {
+	if len(paths) == 0 {
+		return doc
+	}
+
+	result := bson.M{"_id": doc["_id"]}
+	for _, path := range paths {
+		value, found := lookupPath(doc, path)
+		if !found {
+			continue
+		}
+
+		keys := strings.Split(path, ".")
+		target := result
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := target[key].(bson.M)
+			if !ok {
+				child = bson.M{}
+				target[key] = child
+			}
+
+			target = child
+		}
+
+		target[keys[len(keys)-1]] = value
+	}
+
+	return result
+}
+
+// withSortFields returns the options with the fields they sort by added to
+// the projected fields, if they project any, as a page token is made of them.
+func (o *FindOptions) withSortFields() *FindOptions {
+	if len(o.Fields) == 0 {
+		return o
+	}
+
+	result := *o
+	result.Fields = slices.Clone(o.Fields)
+	for _, field := range o.sortSpec() {
+		if field.Path != "_id" && !slices.Contains(result.Fields, field.Path) {
+			result.Fields = append(result.Fields, field.Path)
+		}
+	}
+
+	return &result
+}
+
+// projectPerson returns a copy of the person with only the given fields and the id.
+func projectPerson(person *Person, paths []string) (*Person, error) {
+	doc, err := toDocument(*person)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromDocument(projectDocument(doc, paths))
+}
+
+// buildLinkHeader returns a RFC 8288 Link header value for the pages of the
+// result. A request that pages with offset gets the first, prev, next and last
+// pages by offset. Any other gets the first page, and the next page with the
+// next page token, unless next is empty.
+func buildLinkHeader(requestURL *url.URL, opts *FindOptions, total int64, next string) string {
+	link := func(param, value, rel string) string {
+		query := requestURL.Query()
+		query.Del("offset")
+		query.Del("page_token")
+		query.Set("limit", strconv.FormatInt(opts.Limit, 10))
+		if param != "" {
+			query.Set(param, value)
+		}
+
+		target := url.URL{Path: requestURL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.String(), rel)
+	}
+
+	if !requestURL.Query().Has("offset") {
+		links := []string{link("", "", "first")}
+		if next != "" {
+			links = append(links, link("page_token", next, "next"))
+		}
+
+		return strings.Join(links, ", ")
+	}
+
+	offset := func(value int64, rel string) string {
+		return link("offset", strconv.FormatInt(value, 10), rel)
+	}
+
+	last := int64(0)
+	if total > 0 {
+		last = (total - 1) / opts.Limit * opts.Limit
+	}
+
+	links := []string{offset(0, "first")}
+	if opts.Offset > 0 {
+		prev := opts.Offset - opts.Limit
+		if prev < 0 {
+			prev = 0
+		}
+
+		links = append(links, offset(prev, "prev"))
+	}
+
+	if opts.Offset+opts.Limit < total {
+		links = append(links, offset(opts.Offset+opts.Limit, "next"))
+	}
+
+	links = append(links, offset(last, "last"))
+	return strings.Join(links, ", ")
+}
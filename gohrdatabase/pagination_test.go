@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestParseFindOptions tests the function parseFindOptions
+func TestParseFindOptions(t *testing.T) {
+	tests := []struct {
+		Query  string
+		Result *FindOptions
+	}{
+		{"", &FindOptions{Limit: defaultPageSize}},
+		{"limit=5&offset=10", &FindOptions{Limit: 5, Offset: 10}},
+		{
+			"sort=-lastname&page_token=" + encodePageToken(pageToken{Sort: []string{"-lastname", "_id"}, Key: []interface{}{"Smith", "650000000000000000000000"}}),
+			&FindOptions{
+				Limit: defaultPageSize,
+				After: &pageToken{Sort: []string{"-lastname", "_id"}, Key: []interface{}{"Smith", primitive.ObjectID{0x65}}},
+				Sort:  []SortField{{Path: "lastname", Descending: true}},
+			},
+		},
+		{"sort=lastname,-firstname", &FindOptions{Limit: defaultPageSize, Sort: []SortField{{Path: "lastname"}, {Path: "firstname", Descending: true}}}},
+		{"sort=-id&sort=location.city", &FindOptions{Limit: defaultPageSize, Sort: []SortField{{Path: "_id", Descending: true}, {Path: "location.city"}}}},
+		{"fields=firstname,location.city", &FindOptions{Limit: defaultPageSize, Fields: []string{"firstname", "location.city"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Query, func(t *testing.T) {
+			values, err := url.ParseQuery(tc.Query)
+			assert.NoError(t, err)
+
+			actual, err := parseFindOptions(values)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, actual)
+		})
+	}
+}
+
+// TestParseFindOptionsErrors tests that invalid paging, sort and field parameters are rejected.
+func TestParseFindOptionsErrors(t *testing.T) {
+	for _, query := range []string{
+		"limit=0",
+		"limit=1001",
+		"limit=ten",
+		"offset=-1",
+		"offset=1&page_token=" + encodePageToken(pageToken{Sort: []string{"_id"}, Key: []interface{}{"650000000000000000000000"}}),
+		"page_token=not-a-token",
+		"page_token=" + encodePageToken(pageToken{Sort: []string{"_id"}, Key: []interface{}{nil}}),
+		"page_token=" + encodePageToken(pageToken{Sort: []string{"_id"}, Key: []interface{}{"not-an-id"}}),
+		"page_token=" + encodePageToken(pageToken{Sort: []string{"lastname", "_id"}, Key: []interface{}{3, "650000000000000000000000"}}),
+		"sort=firstname&page_token=" + encodePageToken(pageToken{Sort: []string{"lastname", "_id"}, Key: []interface{}{"Smith", "650000000000000000000000"}}),
+		"sort=salary",
+		"sort=location",
+		"sort=--lastname",
+		"fields=password",
+	} {
+		values, err := url.ParseQuery(query)
+		assert.NoError(t, err)
+
+		_, err = parseFindOptions(values)
+		assert.Error(t, err, query)
+	}
+}
+
+// TestBuildLinkHeader tests the function buildLinkHeader
+func TestBuildLinkHeader(t *testing.T) {
+	requestURL, _ := url.Parse("/person?city=London&limit=10&offset=10")
+	actual := buildLinkHeader(requestURL, &FindOptions{Limit: 10, Offset: 10}, 35, "")
+
+	assert.Equal(t, `</person?city=London&limit=10&offset=0>; rel="first", `+
+		`</person?city=London&limit=10&offset=0>; rel="prev", `+
+		`</person?city=London&limit=10&offset=20>; rel="next", `+
+		`</person?city=London&limit=10&offset=30>; rel="last"`, actual)
+
+	requestURL, _ = url.Parse("/person?city=London&limit=10&page_token=previous")
+	actual = buildLinkHeader(requestURL, &FindOptions{Limit: 10}, 35, "next")
+
+	assert.Equal(t, `</person?city=London&limit=10>; rel="first", `+
+		`</person?city=London&limit=10&page_token=next>; rel="next"`, actual)
+
+	actual = buildLinkHeader(requestURL, &FindOptions{Limit: 10}, 35, "")
+	assert.Equal(t, `</person?city=London&limit=10>; rel="first"`, actual)
+}
+
+// TestPageTokens tests that following the page tokens of GET /person visits
+// every person once, even when people are deleted between pages.
+func TestPageTokens(t *testing.T) {
+	tests := []struct {
+		Name string
+		Sort string
+	}{
+		{"id", ""},
+		{"lastname", "lastname,-firstname"},
+		{"descending_city", "-location.city"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := NewMemoryStore()
+			people := make(People, 25)
+			for i := range people {
+				people[i] = Person{Firstname: fmt.Sprintf("Person%02d", i), Lastname: fmt.Sprintf("Name%d", i%4)}
+				if i%3 != 0 {
+					people[i].Location = &Location{City: fmt.Sprintf("City%d", i%5)}
+				}
+			}
+
+			store.seed(people)
+
+			seen := make(map[string]bool)
+			target := "/person?limit=4&fields=firstname&sort=" + tc.Sort
+			for page := 0; target != ""; page++ {
+				rec := serve(store, http.MethodGet, target, "")
+				assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+				var result []Person
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+				for _, person := range result {
+					assert.False(t, seen[person.Firstname], person.Firstname)
+					assert.Empty(t, person.Lastname)
+					seen[person.Firstname] = true
+				}
+
+				// With offsets, deleting a person of a previous page would
+				// skip the first person of the next one.
+				if page == 1 {
+					assert.Equal(t, http.StatusOK, serve(store, http.MethodDelete, "/person/"+result[0].ID.Hex(), "").Code)
+				}
+
+				target = ""
+				for _, link := range strings.Split(rec.Header().Get("Link"), ", ") {
+					if next, ok := strings.CutSuffix(link, `>; rel="next"`); ok {
+						target = strings.TrimPrefix(next, "<")
+					}
+				}
+			}
+
+			assert.Len(t, seen, 25)
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v3"
+)
+
+// A Permission allows the callers with a role to perform a kind of store operation.
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionCreate Permission = "create"
+	PermissionUpdate Permission = "update"
+	PermissionDelete Permission = "delete"
+)
+
+// A Role grants permissions, and hides sensitive fields from the callers with it.
+type Role struct {
+	Permissions []Permission `yaml:"permissions"`
+	// HiddenFields are the JSON paths of the fields removed from the people
+	// returned to the role, e.g. "location" or "location.city". They cannot
+	// be used to filter or sort by either.
+	HiddenFields []string `yaml:"hidden_fields"`
+}
+
+// A Policy maps role names to what the callers with them may do. A caller with
+// several roles has all of their permissions, and can see a field if any of
+// their roles with the read permission can.
+type Policy struct {
+	Roles map[string]Role `yaml:"roles"`
+}
+
+// DefaultPolicy returns the policy used when no policy file is configured:
+// viewers may read people without their location or salary band, editors may
+// also create and change people, and HR admins may do everything.
+func DefaultPolicy() *Policy {
+	return &Policy{Roles: map[string]Role{
+		"viewer": {
+			Permissions:  []Permission{PermissionRead},
+			HiddenFields: []string{"location", "employment.salary_band"},
+		},
+		"editor": {
+			Permissions: []Permission{PermissionRead, PermissionCreate, PermissionUpdate},
+		},
+		"hr-admin": {
+			Permissions: []Permission{PermissionRead, PermissionCreate, PermissionUpdate, PermissionDelete},
+		},
+	}}
+}
+
+// LoadPolicy reads a YAML policy file, see policy.example.yaml.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+
+	var policy Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+
+	if err = policy.Validate(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+// Validate checks that every role has known permissions and hides known fields.
+// Roles that hide fields may only read, as a write could reveal or overwrite
+// the values they cannot see.
+func (p *Policy) Validate() error {
+	if len(p.Roles) == 0 {
+		return fmt.Errorf("no roles are defined")
+	}
+
+	fields := getPeopleFields()
+	for _, name := range sortedKeys(p.Roles) {
+		role := p.Roles[name]
+		for _, permission := range role.Permissions {
+			switch permission {
+			case PermissionRead, PermissionCreate, PermissionUpdate, PermissionDelete:
+			default:
+				return fmt.Errorf("role %s has unknown permission %q", name, permission)
+			}
+		}
+
+		for _, field := range role.HiddenFields {
+			if _, ok := fields[field]; !ok || field == "id" {
+				return fmt.Errorf("role %s hides unknown field %q", name, field)
+			}
+		}
+
+		if len(role.HiddenFields) > 0 && (len(role.Permissions) != 1 || role.Permissions[0] != PermissionRead) {
+			return fmt.Errorf("role %s hides fields, so it may only have the read permission", name)
+		}
+	}
+
+	return nil
+}
+
+// An access is what a caller may do, given all of their roles.
+type access struct {
+	permissions map[Permission]bool
+	// hidden are the JSON paths of the fields the caller cannot see.
+	hidden []string
+}
+
+// accessFor combines the roles into what a caller with all of them may do.
+// Unknown roles grant nothing.
+func (p *Policy) accessFor(roles []string) access {
+	result := access{permissions: make(map[Permission]bool)}
+
+	// A field is hidden only if every role that can read hides it.
+	var hidden map[string]bool
+	for _, name := range roles {
+		role, ok := p.Roles[name]
+		if !ok {
+			continue
+		}
+
+		for _, permission := range role.Permissions {
+			result.permissions[permission] = true
+			if permission != PermissionRead {
+				continue
+			}
+
+			roleHidden := make(map[string]bool)
+			for _, field := range role.HiddenFields {
+				if hidden == nil || hidden[field] {
+					roleHidden[field] = true
+				}
+			}
+
+			hidden = roleHidden
+		}
+	}
+
+	for field := range hidden {
+		result.hidden = append(result.hidden, field)
+	}
+
+	sort.Strings(result.hidden)
+	return result
+}
+
+// An authorizedStore is a PersonStore that enforces the policy for the caller
+// identified in the context of each operation. It is the single place where
+// roles are checked, so every handler is covered.
+type authorizedStore struct {
+	PersonStore
+	policy *Policy
+}
+
+// AuthorizeStore returns store with the policy enforced on each operation.
+func AuthorizeStore(store PersonStore, policy *Policy) PersonStore {
+	return &authorizedStore{PersonStore: store, policy: policy}
+}
+
+// authorize returns what the caller may do, or an error wrapping ErrForbidden
+// if they do not have the permission.
+func (s *authorizedStore) authorize(ctx context.Context, permission Permission) (access, error) {
+	identity := IdentityFromContext(ctx)
+	if identity == nil {
+		return access{}, fmt.Errorf("%w: the caller is not authenticated", ErrForbidden)
+	}
+
+	result := s.policy.accessFor(identity.Roles)
+	if !result.permissions[permission] {
+		return access{}, fmt.Errorf("%w: %s does not have the %s permission", ErrForbidden, identity.Subject, permission)
+	}
+
+	// Validate keeps roles that hide fields from writing, but a caller may
+	// combine one with a role that writes without reading.
+	if permission != PermissionRead && len(result.hidden) > 0 {
+		return access{}, fmt.Errorf("%w: %s cannot see %s, so it may only read", ErrForbidden, identity.Subject, strings.Join(result.hidden, ", "))
+	}
+
+	return result, nil
+}
+
+// A permissionChecker is a PersonStore that can check a permission of the
+// caller without performing an operation, as a dry run does.
+type permissionChecker interface {
+	checkPermission(ctx context.Context, permission Permission) error
+}
+
+// checkPermission returns an error wrapping ErrForbidden if the caller does not
+// have the permission.
+func (s *authorizedStore) checkPermission(ctx context.Context, permission Permission) error {
+	_, err := s.authorize(ctx, permission)
+	return err
+}
+
+func (s *authorizedStore) CreatePersonRecord(ctx context.Context, person Person) (*Person, error) {
+	granted, err := s.authorize(ctx, PermissionCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.redactResult(s.PersonStore.CreatePersonRecord(ctx, person))
+}
+
+func (s *authorizedStore) DeletePersonRecord(ctx context.Context, id string, versions ...int64) (*mongo.DeleteResult, error) {
+	if _, err := s.authorize(ctx, PermissionDelete); err != nil {
+		return nil, err
+	}
+
+	return s.PersonStore.DeletePersonRecord(ctx, id, versions...)
+}
+
+func (s *authorizedStore) RestorePersonRecord(ctx context.Context, id string) (*Person, error) {
+	granted, err := s.authorize(ctx, PermissionDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.redactResult(s.PersonStore.RestorePersonRecord(ctx, id))
+}
+
+func (s *authorizedStore) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if _, err := s.authorize(ctx, PermissionDelete); err != nil {
+		return 0, err
+	}
+
+	return s.PersonStore.PurgeDeletedPeople(ctx, deletedBefore)
+}
+
+func (s *authorizedStore) CountPeople(ctx context.Context, query bson.M) (int64, error) {
+	granted, err := s.authorize(ctx, PermissionRead)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = granted.checkQuery(query, nil); err != nil {
+		return 0, err
+	}
+
+	return s.PersonStore.CountPeople(ctx, query)
+}
+
+func (s *authorizedStore) GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) ([]*Person, error) {
+	granted, err := s.authorize(ctx, PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = granted.checkQuery(query, opts); err != nil {
+		return nil, err
+	}
+
+	people, err := s.PersonStore.GetAllPeople(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, person := range people {
+		if people[i], err = granted.redact(person); err != nil {
+			return nil, err
+		}
+	}
+
+	return people, nil
+}
+
+func (s *authorizedStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error {
+	granted, err := s.authorize(ctx, PermissionRead)
+	if err != nil {
+		return err
+	}
+
+	if err = granted.checkQuery(query, opts); err != nil {
+		return err
+	}
+
+	return s.PersonStore.StreamPeople(ctx, query, opts, func(person *Person) error {
+		redacted, err := granted.redact(person)
+		if err != nil {
+			return err
+		}
+
+		return fn(redacted)
+	})
+}
+
+func (s *authorizedStore) GetPersonByObjectId(ctx context.Context, id string) (*Person, error) {
+	granted, err := s.authorize(ctx, PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.redactResult(s.PersonStore.GetPersonByObjectId(ctx, id))
+}
+
+func (s *authorizedStore) PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (*Person, error) {
+	granted, err := s.authorize(ctx, PermissionUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.redactResult(s.PersonStore.PatchPersonRecord(ctx, patch, id))
+}
+
+func (s *authorizedStore) UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (*Person, error) {
+	granted, err := s.authorize(ctx, PermissionUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	return granted.redactResult(s.PersonStore.UpdatePersonRecord(ctx, person, id, versions...))
+}
+
+func (s *authorizedStore) GetPersonHistory(ctx context.Context, id string) ([]AuditEvent, error) {
+	granted, err := s.authorize(ctx, PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.PersonStore.GetPersonHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		events[i].Changes = granted.redactChanges(events[i].Changes)
+	}
+
+	return events, nil
+}
+
+// batchPermissions are the permissions the kinds of operation of a batch need.
+var batchPermissions = map[string]Permission{
+	BatchCreate: PermissionCreate,
+	BatchUpdate: PermissionUpdate,
+	BatchDelete: PermissionDelete,
+}
+
+// ApplyBatch requires the permission of every kind of operation in the batch,
+// so that a batch is either authorized as a whole or not at all.
+func (s *authorizedStore) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	var granted access
+	checked := make(map[string]bool)
+	for _, op := range ops {
+		if checked[op.Op] {
+			continue
+		}
+
+		checked[op.Op] = true
+		var err error
+		if granted, err = s.authorize(ctx, batchPermissions[op.Op]); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := s.PersonStore.ApplyBatch(ctx, ops, atomic)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Person, results[i].Err = granted.redact(results[i].Person)
+		}
+	}
+
+	return results, nil
+}
+
+// redactResult redacts the person returned by a store operation, passing any error through.
+func (a access) redactResult(person *Person, err error) (*Person, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return a.redact(person)
+}
+
+// redact returns a copy of the person without the hidden fields.
+func (a access) redact(person *Person) (*Person, error) {
+	if len(a.hidden) == 0 || person == nil {
+		return person, nil
+	}
+
+	doc, err := toJSONDocument(*person)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range a.hidden {
+		// A field that is not set has nothing to hide.
+		if redacted, err := removeValue(doc, strings.Split(field, ".")); err == nil {
+			doc = redacted
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Person
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// checkQuery returns an error wrapping ErrForbidden if the query filters or the
+// options sort by a hidden field, which would reveal its values.
+func (a access) checkQuery(query bson.M, opts *FindOptions) error {
+	if len(a.hidden) == 0 {
+		return nil
+	}
+
+	var paths []string
+	collectFieldPaths(query, &paths)
+	if opts != nil {
+		for _, field := range opts.Sort {
+			paths = append(paths, field.Path)
+		}
+	}
+
+	for _, path := range paths {
+		for _, hidden := range a.hidden {
+			if path == hidden || strings.HasPrefix(path, hidden+".") || strings.HasPrefix(hidden, path+".") {
+				return fmt.Errorf("%w: cannot filter or sort by %s", ErrForbidden, hidden)
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectFieldPaths appends the field paths a filter document tests to paths.
+func collectFieldPaths(value interface{}, paths *[]string) {
+	switch v := value.(type) {
+	case bson.M:
+		for key, child := range v {
+			if strings.HasPrefix(key, "$") {
+				collectFieldPaths(child, paths)
+			} else {
+				*paths = append(*paths, key)
+			}
+		}
+	case []bson.M:
+		for _, child := range v {
+			collectFieldPaths(child, paths)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectFieldPaths(child, paths)
+		}
+	}
+}
+
+// redactChanges returns the changes of the fields that are not hidden.
+func (a access) redactChanges(changes []FieldChange) []FieldChange {
+	if len(a.hidden) == 0 {
+		return changes
+	}
+
+	visible := []FieldChange{}
+	for _, change := range changes {
+		if !a.isHidden(change.Field) {
+			visible = append(visible, change)
+		}
+	}
+
+	return visible
+}
+
+// isHidden reports whether the field at the dotted path is, or is within, a hidden field.
+func (a access) isHidden(path string) bool {
+	for _, hidden := range a.hidden {
+		if path == hidden || strings.HasPrefix(path, hidden+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadPolicy tests that the example policy is the default and that invalid policies are rejected.
+func TestLoadPolicy(t *testing.T) {
+	policy, err := LoadPolicy("policy.example.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPolicy(), policy)
+	assert.NoError(t, DefaultPolicy().Validate())
+
+	tests := []struct {
+		Name   string
+		Policy string
+	}{
+		{"empty", "roles: {}\n"},
+		{"unknown_setting", "roles:\n  viewer:\n    permissions: [read]\n    fields: [location]\n"},
+		{"unknown_permission", "roles:\n  viewer:\n    permissions: [read, approve]\n"},
+		{"unknown_field", "roles:\n  viewer:\n    permissions: [read]\n    hidden_fields: [salary]\n"},
+		{"hidden_id", "roles:\n  viewer:\n    permissions: [read]\n    hidden_fields: [id]\n"},
+		{"writer_hides_fields", "roles:\n  editor:\n    permissions: [read, update]\n    hidden_fields: [location]\n"},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.Name+".yaml")
+			assert.NoError(t, os.WriteFile(path, []byte(tc.Policy), 0o600))
+
+			_, err := LoadPolicy(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestPolicyAccessFor tests how the permissions and hidden fields of several roles combine.
+func TestPolicyAccessFor(t *testing.T) {
+	policy := &Policy{Roles: map[string]Role{
+		"viewer":  {Permissions: []Permission{PermissionRead}, HiddenFields: []string{"location", "lastname"}},
+		"auditor": {Permissions: []Permission{PermissionRead}, HiddenFields: []string{"location"}},
+		"deleter": {Permissions: []Permission{PermissionDelete}},
+		"editor":  {Permissions: []Permission{PermissionRead, PermissionUpdate}},
+	}}
+
+	tests := []struct {
+		Roles       []string
+		Permissions []Permission
+		Hidden      []string
+	}{
+		{nil, nil, nil},
+		{[]string{"unknown"}, nil, nil},
+		{[]string{"viewer"}, []Permission{PermissionRead}, []string{"lastname", "location"}},
+		{[]string{"viewer", "auditor"}, []Permission{PermissionRead}, []string{"location"}},
+		{[]string{"viewer", "deleter"}, []Permission{PermissionRead, PermissionDelete}, []string{"lastname", "location"}},
+		{[]string{"viewer", "editor"}, []Permission{PermissionRead, PermissionUpdate}, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.Roles, ","), func(t *testing.T) {
+			granted := policy.accessFor(tc.Roles)
+			assert.Len(t, granted.permissions, len(tc.Permissions))
+			for _, permission := range tc.Permissions {
+				assert.True(t, granted.permissions[permission], permission)
+			}
+
+			assert.Equal(t, tc.Hidden, granted.hidden)
+		})
+	}
+}
+
+// TestAuthorizeHiddenFields tests that a caller with hidden fields cannot
+// write, even when another of their roles grants the permission.
+func TestAuthorizeHiddenFields(t *testing.T) {
+	store := &authorizedStore{PersonStore: NewMemoryStore(), policy: &Policy{Roles: map[string]Role{
+		"viewer":   {Permissions: []Permission{PermissionRead}, HiddenFields: []string{"location"}},
+		"importer": {Permissions: []Permission{PermissionCreate, PermissionUpdate, PermissionDelete}},
+	}}}
+	ctx := context.WithValue(context.Background(), identityKey, &Identity{Subject: "sync", Roles: []string{"viewer", "importer"}})
+
+	_, err := store.authorize(ctx, PermissionRead)
+	assert.NoError(t, err)
+
+	for _, permission := range []Permission{PermissionCreate, PermissionUpdate, PermissionDelete} {
+		_, err = store.authorize(ctx, permission)
+		assert.ErrorIs(t, err, ErrForbidden, permission)
+		assert.ErrorContains(t, err, "cannot see location", permission)
+	}
+}
+
+// TestAuthorization tests that the roles of the default policy are enforced on every route.
+func TestAuthorization(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}}})
+	people, _ := store.GetAllPeople(context.Background(), nil, nil)
+	id := people[0].ID.Hex()
+	router := NewRouter(store, DefaultConfig(), newTestAuthenticator(t))
+
+	request := func(key, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	person := `{"firstname":"Jane","lastname":"Doe","location":{"city":"Paris","country":"FR"}}`
+	tests := []struct {
+		Method string
+		Target string
+		Body   string
+		Viewer int
+		Editor int
+		Admin  int
+	}{
+		{http.MethodGet, "/person", "", http.StatusOK, http.StatusOK, http.StatusOK},
+		{http.MethodGet, "/person/" + id, "", http.StatusOK, http.StatusOK, http.StatusOK},
+		{http.MethodPost, "/person", person, http.StatusForbidden, http.StatusOK, http.StatusOK},
+		{http.MethodPut, "/person/" + id, person, http.StatusForbidden, http.StatusOK, http.StatusOK},
+		{http.MethodPatch, "/person/" + id, `[{"op":"replace","path":"/firstname","value":"Jack"}]`, http.StatusForbidden, http.StatusOK, http.StatusOK},
+		{http.MethodDelete, "/person/" + id, "", http.StatusForbidden, http.StatusForbidden, http.StatusOK},
+		{http.MethodPost, "/person/" + id + "/restore", "", http.StatusForbidden, http.StatusForbidden, http.StatusOK},
+		{http.MethodPost, "/person:batch", `[{"op":"create","person":` + person + `}]`, http.StatusForbidden, http.StatusOK, http.StatusOK},
+		{http.MethodPost, "/person:batch", `[{"op":"create","person":` + person + `},{"op":"delete","id":"` + id + `"}]`, http.StatusForbidden, http.StatusForbidden, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Method+" "+tc.Target, func(t *testing.T) {
+			assert.Equal(t, tc.Viewer, request(testViewerAPIKey, tc.Method, tc.Target, tc.Body).Code, "viewer")
+			assert.Equal(t, tc.Editor, request(testAPIKey, tc.Method, tc.Target, tc.Body).Code, "editor")
+			assert.Equal(t, tc.Admin, request(testAdminAPIKey, tc.Method, tc.Target, tc.Body).Code, "hr-admin")
+		})
+	}
+}
+
+// TestAuthorizationHiddenFields tests that viewers cannot see, filter or sort by
+// the location or the salary band.
+func TestAuthorizationHiddenFields(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{{
+		Firstname:  "John",
+		Lastname:   "Smith",
+		Location:   &Location{City: "London", Country: "GB"},
+		Employment: &Employment{Department: "Sales", SalaryBand: "B6"},
+	}})
+	router := NewRouter(store, DefaultConfig(), newTestAuthenticator(t))
+
+	request := func(key, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var people []map[string]interface{}
+	rec := request(testViewerAPIKey, "/person")
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&people))
+	if assert.Len(t, people, 1) {
+		assert.Equal(t, "John", people[0]["firstname"])
+		assert.NotContains(t, people[0], "location")
+		assert.Equal(t, map[string]interface{}{"department": "Sales"}, people[0]["employment"])
+
+		rec = request(testViewerAPIKey, "/person/"+people[0]["id"].(string))
+		assert.NotContains(t, rec.Body.String(), "London")
+	}
+
+	for _, target := range []string{"/person?city=London", "/person?q=country!=FR", "/person?sort=location.city", "/person?q=firstname=John+OR+location.city=London", "/person?salary_band=B6", "/person?sort=employment.salary_band"} {
+		assert.Equal(t, http.StatusForbidden, request(testViewerAPIKey, target).Code, target)
+		assert.Equal(t, http.StatusOK, request(testAPIKey, target).Code, target)
+	}
+
+	rec = request(testAPIKey, "/person")
+	assert.Contains(t, rec.Body.String(), "London")
+	assert.Contains(t, rec.Body.String(), "B6")
+
+	stream := func(key, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(APIKeyHeader, key)
+		req.Header.Set("Accept", ndjsonMediaType)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = stream(testViewerAPIKey, "/person")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "John")
+	assert.NotContains(t, rec.Body.String(), "London")
+	assert.Equal(t, http.StatusForbidden, stream(testViewerAPIKey, "/person?sort=location.city").Code)
+	assert.Contains(t, stream(testAPIKey, "/person").Body.String(), "London")
+}
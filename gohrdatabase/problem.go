@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// StatusClientClosedRequest is the non-standard status logged when the client
+// goes away before the response is written, as popularised by nginx.
+const StatusClientClosedRequest = 499
+
+// A Problem is a RFC 7807 problem details error response - https://datatracker.ietf.org/doc/html/rfc7807
+type Problem struct {
+	Type          string      `json:"type"`
+	Title         string      `json:"title"`
+	Status        int         `json:"status"`
+	Detail        string      `json:"detail,omitempty"`
+	Instance      string      `json:"instance,omitempty"`
+	InvalidParams []Violation `json:"invalid-params,omitempty"`
+	// RequestID is the X-Request-ID of the request, to correlate the problem with the logs.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeProblem replies to the request with a problem details body for the status code.
+func writeProblem(w http.ResponseWriter, req *http.Request, status int, detail string) {
+	writeProblemDetails(w, req, newProblem(req, status, detail))
+}
+
+// writeError replies to the request with a problem for err, see errorProblem.
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	writeProblemDetails(w, req, errorProblem(req, err))
+}
+
+// newProblem returns a problem details body for the status code.
+func newProblem(req *http.Request, status int, detail string) *Problem {
+	return &Problem{
+		Type:     "about:blank",
+		Title:    statusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+	}
+}
+
+// errorProblem returns the problem for err, mapping the errors returned by the
+// store to HTTP status codes.
+func errorProblem(req *http.Request, err error) *Problem {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return validationProblem(req, validationErr)
+	case errors.Is(err, ErrNotFound):
+		return newProblem(req, http.StatusNotFound, "Person not found.")
+	case errors.Is(err, ErrInvalidID):
+		return newProblem(req, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrConflict):
+		return newProblem(req, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrValidation):
+		return newProblem(req, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrPreconditionFailed):
+		return newProblem(req, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrForbidden):
+		return newProblem(req, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrBatchAborted):
+		return newProblem(req, http.StatusFailedDependency, err.Error())
+	case errors.Is(err, context.Canceled):
+		return newProblem(req, StatusClientClosedRequest, "The request was cancelled.")
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		return newProblem(req, http.StatusGatewayTimeout, "The database did not respond in time.")
+	default:
+		return newProblem(req, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// validationProblem returns a 422 problem listing each field violation.
+func validationProblem(req *http.Request, err *ValidationError) *Problem {
+	return &Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(http.StatusUnprocessableEntity),
+		Status:        http.StatusUnprocessableEntity,
+		Detail:        "The person is not valid.",
+		Instance:      req.URL.Path,
+		InvalidParams: err.Violations,
+	}
+}
+
+// statusText returns the text for the status code, including StatusClientClosedRequest.
+func statusText(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+
+	return http.StatusText(status)
+}
+
+func writeProblemDetails(w http.ResponseWriter, req *http.Request, problem *Problem) {
+	writeExtendedProblem(w, req, problem, problem)
+}
+
+// writeExtendedProblem replies to the request with the problem as body, a value
+// that embeds the problem to add extension members of its own.
+func writeExtendedProblem(w http.ResponseWriter, req *http.Request, problem *Problem, body interface{}) {
+	problem.RequestID = RequestID(req.Context())
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(body)
+}
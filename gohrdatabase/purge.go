@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// purgeIdentity is the actor of the purges in the audit trail.
+var purgeIdentity = &Identity{Subject: "purge-job", Method: "system"}
+
+// runPurge permanently removes the people deleted more than retention ago from
+// the store, once when it starts and then every interval, until ctx is done.
+// Nothing is purged if retention is zero.
+func runPurge(ctx context.Context, store PersonStore, retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeDeletedPeople(ctx, store, retention, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedPeople runs one purge, which may take at most timeout, and logs its outcome.
+func purgeDeletedPeople(ctx context.Context, store PersonStore, retention, timeout time.Duration) {
+	purgeCtx, cancel := context.WithTimeout(context.WithValue(ctx, identityKey, purgeIdentity), timeout)
+	defer cancel()
+
+	purged, err := store.PurgeDeletedPeople(purgeCtx, time.Now().Add(-retention))
+	if err != nil {
+		// A purge cut short by shutdown is resumed on the next start.
+		if ctx.Err() == nil {
+			slog.Error("cannot purge deleted people", "purged", purged, "error", err)
+		}
+
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("purged deleted people", "purged", purged, "retention", retention.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestPurgeDeletedPeople tests that only the people deleted before the
+// retention period are purged, and that their history records the purge
+// without the values of their fields.
+func TestPurgeDeletedPeople(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(People{
+		{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+		{Firstname: "Emma", Lastname: "Jones"},
+		{Firstname: "Ava", Lastname: "Brown"},
+	})
+
+	ids := make(map[string]string)
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	for _, person := range people {
+		ids[person.Firstname] = person.ID.Hex()
+	}
+
+	_, err = store.PatchPersonRecord(context.Background(), MergePatch(`{"location":{"city":"Leeds"}}`), ids["John"])
+	assert.NoError(t, err)
+
+	for _, name := range []string{"John", "Emma"} {
+		_, err = store.DeletePersonRecord(context.Background(), ids[name])
+		assert.NoError(t, err)
+	}
+
+	// John was deleted long ago.
+	john := store.people[ids["John"]]
+	deletedAt := john.DeletedAt.Add(-48 * time.Hour)
+	john.DeletedAt = &deletedAt
+	store.people[ids["John"]] = john
+
+	purgeDeletedPeople(context.Background(), store, 24*time.Hour, time.Minute)
+
+	count, err := store.CountPeople(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	_, err = store.RestorePersonRecord(context.Background(), ids["John"])
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = store.RestorePersonRecord(context.Background(), ids["Emma"])
+	assert.NoError(t, err)
+
+	events, err := store.GetPersonHistory(context.Background(), ids["John"])
+	assert.NoError(t, err)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, []FieldChange{{Field: "location.city"}}, events[0].Changes)
+		assert.Equal(t, []FieldChange{{Field: "deleted_at"}}, events[1].Changes)
+		assert.Equal(t, AuditPurge, events[2].Action)
+		assert.Equal(t, purgeIdentity.Subject, events[2].Actor)
+		assert.Contains(t, events[2].Changes, FieldChange{Field: "firstname"})
+		assert.NotContains(t, fmt.Sprint(events), "Smith")
+	}
+
+	events, err = store.GetPersonHistory(context.Background(), ids["Emma"])
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.NotNil(t, events[0].Changes[0].After)
+	}
+}
+
+// TestRunPurge tests that the purge job does nothing without a retention
+// period, and stops when its context is done.
+func TestRunPurge(t *testing.T) {
+	store := NewMemoryStore()
+	store.seed(generatePeople(1))
+	people, err := store.GetAllPeople(context.Background(), nil, nil)
+	assert.NoError(t, err)
+
+	_, err = store.DeletePersonRecord(context.Background(), people[0].ID.Hex())
+	assert.NoError(t, err)
+
+	runPurge(context.Background(), store, 0, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runPurge(ctx, store, time.Hour, time.Millisecond)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	count, err := store.CountPeople(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
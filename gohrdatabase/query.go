@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A QueryNode is a node in the abstract syntax tree of a query.
+type QueryNode interface {
+	// ToBSON translates the node into a MongoDB filter document.
+	ToBSON() bson.M
+}
+
+// An AndNode matches if all of its children match.
+type AndNode struct {
+	Children []QueryNode
+}
+
+// An OrNode matches if any of its children match.
+type OrNode struct {
+	Children []QueryNode
+}
+
+// A NotNode matches if its child does not match.
+type NotNode struct {
+	Child QueryNode
+}
+
+// A MatchKind is the way a Comparison compares a field with its values.
+type MatchKind int
+
+const (
+	MatchEqual MatchKind = iota
+	MatchPrefix
+	MatchContains
+)
+
+// A Comparison matches a field against one or more values.
+type Comparison struct {
+	// Field is the BSON path of the field, e.g. "location.city".
+	Field      string
+	Match      MatchKind
+	Negate     bool
+	IgnoreCase bool
+	Values     []string
+}
+
+// ToBSON returns the children as a single filter document, or combined with $and
+// when two children filter the same field.
+func (n *AndNode) ToBSON() bson.M {
+	filter := bson.M{}
+	children := make([]bson.M, 0, len(n.Children))
+	for _, child := range n.Children {
+		children = append(children, child.ToBSON())
+	}
+
+	for _, child := range children {
+		for key, value := range child {
+			if _, ok := filter[key]; ok {
+				return bson.M{"$and": children}
+			}
+
+			filter[key] = value
+		}
+	}
+
+	return filter
+}
+
+// ToBSON returns the children combined with $or.
+func (n *OrNode) ToBSON() bson.M {
+	children := make([]bson.M, 0, len(n.Children))
+	for _, child := range n.Children {
+		children = append(children, child.ToBSON())
+	}
+
+	return bson.M{"$or": children}
+}
+
+// ToBSON returns the child negated with $nor.
+func (n *NotNode) ToBSON() bson.M {
+	return bson.M{"$nor": []bson.M{n.Child.ToBSON()}}
+}
+
+// ToBSON returns an $in or $nin filter for exact comparisons, and a $regex
+// filter for each value otherwise.
+func (c *Comparison) ToBSON() bson.M {
+	if c.Match == MatchEqual && !c.IgnoreCase {
+		if c.Negate {
+			return bson.M{c.Field: bson.M{"$nin": c.Values}}
+		}
+
+		return bson.M{c.Field: bson.M{"$in": c.Values}}
+	}
+
+	conditions := make([]bson.M, 0, len(c.Values))
+	for _, value := range c.Values {
+		conditions = append(conditions, bson.M{c.Field: c.regex(value)})
+	}
+
+	switch {
+	case c.Negate:
+		return bson.M{"$nor": conditions}
+	case len(conditions) == 1:
+		return conditions[0]
+	}
+
+	return bson.M{"$or": conditions}
+}
+
+func (c *Comparison) regex(value string) bson.M {
+	pattern := regexp.QuoteMeta(value)
+	switch c.Match {
+	case MatchEqual:
+		pattern = "^" + pattern + "$"
+	case MatchPrefix:
+		pattern = "^" + pattern
+	}
+
+	if c.IgnoreCase {
+		return bson.M{"$regex": pattern, "$options": "i"}
+	}
+
+	return bson.M{"$regex": pattern}
+}
+
+// parseQuery parses the query parameters from an HTTP request into MongoDB filter criteria.
+// It takes the URL query values and a list of QueryFilter structs, constructs filter criteria
+// based on the query parameters and the q expression, and returns a BSON filter document
+// suitable for MongoDB queries. Parameters that are not filters are ignored.
+//
+// Simple filters are given as query parameters, where the parameter name is a
+// field followed by an optional operator and the values are comma separated.
+// Every parameter must match:
+//
+//	/person?city=London,Paris&lastname^=Sm&country!=GB
+//
+// Boolean combinations are given as an expression in the q parameter:
+//
+//	/person?q=(city=London OR city="New York") AND NOT lastname~*=smith
+//
+// The grammar of q is:
+//
+//	query      = or-expr
+//	or-expr    = and-expr { "OR" and-expr }
+//	and-expr   = not-expr { "AND" not-expr }
+//	not-expr   = "NOT" not-expr | primary
+//	primary    = "(" or-expr ")" | comparison
+//	comparison = field operator value { "," value }
+//	operator   = [ "!" ] [ "^" | "~" ] [ "*" ] "="
+//	value      = bare-value | '"' { character | '\"' | '\\' } '"'
+//
+// Keywords are case-insensitive and a bare value ends at whitespace, ',', '('
+// or ')'. Fields are those of getPeopleQueryFilter: firstname, lastname,
+// employee_number, email and phone, the city and country of the location, and
+// the job_title, department, manager_id, start_date, end_date, status and
+// salary_band of the employment. The fields of the location and employment may
+// also be written with their parent, e.g. location.city or employment.status.
+// Dates are compared as YYYY-MM-DD strings, so start_date^=2024 matches the
+// people who started in 2024.
+//
+// The operator "=" matches equal values, "^=" values starting with and "~="
+// values containing the given value. A "!" negates the comparison and a "*"
+// makes it case-insensitive, so "!^*=" matches values that do not start with
+// the given value in any case. A comparison with several values matches if any
+// of the values match, or when negated if none of them do.
+func parseQuery(queryValues url.Values, queryFilters []QueryFilter) (bson.M, error) {
+	fields := getQueryFields(queryFilters)
+
+	keys := make([]string, 0, len(queryValues))
+	for key := range queryValues {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var nodes []QueryNode
+	for _, key := range keys {
+		item := queryValues[key]
+		if key == "q" {
+			for _, expression := range item {
+				node, err := ParseQueryExpression(expression, queryFilters)
+				if err != nil {
+					return nil, err
+				}
+
+				nodes = append(nodes, node)
+			}
+
+			continue
+		}
+
+		name := strings.TrimRight(key, "!^~*")
+		path, found := fields[name]
+		if !found {
+			if name != key {
+				return nil, fmt.Errorf("unknown query field %q", name)
+			}
+
+			continue
+		}
+
+		comparison, err := newComparison(path, key[len(name):]+"=")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(item) == 1 {
+			comparison.Values = strings.Split(item[0], ",")
+		} else {
+			comparison.Values = item
+		}
+
+		nodes = append(nodes, comparison)
+	}
+
+	switch len(nodes) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return nodes[0].ToBSON(), nil
+	}
+
+	return (&AndNode{Children: nodes}).ToBSON(), nil
+}
+
+// ParseQueryExpression parses a q expression into its abstract syntax tree.
+func ParseQueryExpression(expression string, queryFilters []QueryFilter) (QueryNode, error) {
+	p := &queryParser{input: expression, fields: getQueryFields(queryFilters)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return node, nil
+}
+
+// getQueryFields maps the names a field can be queried by to its BSON path.
+func getQueryFields(queryFilters []QueryFilter) map[string]string {
+	fields := make(map[string]string)
+	for _, queryFilter := range queryFilters {
+		path := queryFilter.Name
+		if queryFilter.ParentPath != "" {
+			path = queryFilter.ParentPath + "." + path
+		}
+
+		fields[queryFilter.Name] = path
+		fields[path] = path
+	}
+
+	return fields
+}
+
+var operatorPattern = regexp.MustCompile(`^(!?)([\^~]?)(\*?)=$`)
+
+// newComparison returns an empty comparison on path for the given operator.
+func newComparison(path, operator string) (*Comparison, error) {
+	parts := operatorPattern.FindStringSubmatch(operator)
+	if parts == nil {
+		return nil, fmt.Errorf("unknown query operator %q", operator)
+	}
+
+	comparison := &Comparison{Field: path, Negate: parts[1] == "!", IgnoreCase: parts[3] == "*"}
+	switch parts[2] {
+	case "^":
+		comparison.Match = MatchPrefix
+	case "~":
+		comparison.Match = MatchContains
+	}
+
+	return comparison, nil
+}
+
+// queryParser is a recursive descent parser for q expressions.
+type queryParser struct {
+	input  string
+	pos    int
+	fields map[string]string
+}
+
+func (p *queryParser) parseOr() (QueryNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []QueryNode{node}
+	for p.acceptKeyword("OR") {
+		if node, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+
+		children = append(children, node)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return &OrNode{Children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (QueryNode, error) {
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []QueryNode{node}
+	for p.acceptKeyword("AND") {
+		if node, err = p.parseNot(); err != nil {
+			return nil, err
+		}
+
+		children = append(children, node)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return &AndNode{Children: children}, nil
+}
+
+func (p *queryParser) parseNot() (QueryNode, error) {
+	if p.acceptKeyword("NOT") {
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotNode{Child: child}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (QueryNode, error) {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (QueryNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && isFieldChar(rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	name := p.input[start:p.pos]
+	if name == "" {
+		return nil, p.errorf("expected a field")
+	}
+
+	path, ok := p.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown query field %q", name)
+	}
+
+	start = p.pos
+	for p.pos < len(p.input) && strings.ContainsRune("!^~*", rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	if p.pos < len(p.input) && p.input[p.pos] == '=' {
+		p.pos++
+	}
+
+	comparison, err := newComparison(path, p.input[start:p.pos])
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		comparison.Values = append(comparison.Values, value)
+		if p.pos >= len(p.input) || p.input[p.pos] != ',' {
+			break
+		}
+
+		p.pos++
+	}
+
+	return comparison, nil
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	if p.pos < len(p.input) && p.input[p.pos] == '"' {
+		var value strings.Builder
+		for p.pos++; p.pos < len(p.input); p.pos++ {
+			switch c := p.input[p.pos]; c {
+			case '\\':
+				p.pos++
+				if p.pos < len(p.input) {
+					value.WriteByte(p.input[p.pos])
+				}
+			case '"':
+				p.pos++
+				return value.String(), nil
+			default:
+				value.WriteByte(c)
+			}
+		}
+
+		return "", p.errorf("unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(",()", rune(p.input[p.pos])) && !unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	if start == p.pos {
+		return "", p.errorf("expected a value")
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+// acceptKeyword consumes the keyword if it is next in the input.
+func (p *queryParser) acceptKeyword(keyword string) bool {
+	p.skipSpace()
+	end := p.pos + len(keyword)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], keyword) {
+		return false
+	}
+
+	if end < len(p.input) && isFieldChar(rune(p.input[end])) {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid query at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func isFieldChar(c rune) bool {
+	return c == '.' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestParseQueryExpression tests the function ParseQueryExpression
+func TestParseQueryExpression(t *testing.T) {
+	tests := []struct {
+		Expression string
+		Result     QueryNode
+	}{
+		{
+			"city=London,Paris",
+			&Comparison{Field: "location.city", Values: []string{"London", "Paris"}},
+		},
+		{
+			`location.city!~*="new york"`,
+			&Comparison{Field: "location.city", Match: MatchContains, Negate: true, IgnoreCase: true, Values: []string{"new york"}},
+		},
+		{
+			"firstname=John or lastname^=Sm and country=GB",
+			&OrNode{Children: []QueryNode{
+				&Comparison{Field: "firstname", Values: []string{"John"}},
+				&AndNode{Children: []QueryNode{
+					&Comparison{Field: "lastname", Match: MatchPrefix, Values: []string{"Sm"}},
+					&Comparison{Field: "location.country", Values: []string{"GB"}},
+				}},
+			}},
+		},
+		{
+			"NOT (firstname=John OR firstname=Emma) AND NOT NOT lastname=Smith",
+			&AndNode{Children: []QueryNode{
+				&NotNode{Child: &OrNode{Children: []QueryNode{
+					&Comparison{Field: "firstname", Values: []string{"John"}},
+					&Comparison{Field: "firstname", Values: []string{"Emma"}},
+				}}},
+				&NotNode{Child: &NotNode{Child: &Comparison{Field: "lastname", Values: []string{"Smith"}}}},
+			}},
+		},
+		{
+			"status=active AND employment.start_date^=2024 AND email~*=@EXAMPLE.COM",
+			&AndNode{Children: []QueryNode{
+				&Comparison{Field: "employment.status", Values: []string{"active"}},
+				&Comparison{Field: "employment.start_date", Match: MatchPrefix, Values: []string{"2024"}},
+				&Comparison{Field: "email", Match: MatchContains, IgnoreCase: true, Values: []string{"@EXAMPLE.COM"}},
+			}},
+		},
+		{
+			`lastname="O\"Brien",Smith`,
+			&Comparison{Field: "lastname", Values: []string{`O"Brien`, "Smith"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Expression, func(t *testing.T) {
+			actual, err := ParseQueryExpression(tc.Expression, getPeopleQueryFilter())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, actual)
+		})
+	}
+}
+
+// TestParseQueryExpressionErrors tests that malformed expressions are rejected.
+func TestParseQueryExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"salary=10",
+		"firstname",
+		"firstname=",
+		"firstname=John AND",
+		"(firstname=John",
+		"firstname=John)",
+		`firstname="John`,
+		"firstname=John lastname=Smith",
+	} {
+		_, err := ParseQueryExpression(expression, getPeopleQueryFilter())
+		assert.Error(t, err, expression)
+	}
+}
+
+// TestParseQuery tests the function parseQuery
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		Query  string
+		Result bson.M
+	}{
+		{"", bson.M{}},
+		{"limit=10&sort=lastname", bson.M{}},
+		{
+			"city=London,Paris&firstname=John&firstname=Emma",
+			bson.M{
+				"location.city": bson.M{"$in": []string{"London", "Paris"}},
+				"firstname":     bson.M{"$in": []string{"John", "Emma"}},
+			},
+		},
+		{
+			"country!=GB&lastname^*=sm",
+			bson.M{
+				"location.country": bson.M{"$nin": []string{"GB"}},
+				"lastname":         bson.M{"$regex": "^sm", "$options": "i"},
+			},
+		},
+		{
+			"lastname~=a.b,c",
+			bson.M{"$or": []bson.M{
+				{"lastname": bson.M{"$regex": `a\.b`}},
+				{"lastname": bson.M{"$regex": "c"}},
+			}},
+		},
+		{
+			"firstname!*=john",
+			bson.M{"$nor": []bson.M{{"firstname": bson.M{"$regex": "^john$", "$options": "i"}}}},
+		},
+		{
+			"firstname=John&q=firstname=Emma",
+			bson.M{"$and": []bson.M{
+				{"firstname": bson.M{"$in": []string{"John"}}},
+				{"firstname": bson.M{"$in": []string{"Emma"}}},
+			}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Query, func(t *testing.T) {
+			values, err := url.ParseQuery(tc.Query)
+			assert.NoError(t, err)
+
+			actual, err := parseQuery(values, getPeopleQueryFilter())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Result, actual)
+		})
+	}
+
+	for _, query := range []string{"salary!=10", "firstname!!=John", "q=firstname"} {
+		values, _ := url.ParseQuery(query)
+		_, err := parseQuery(values, getPeopleQueryFilter())
+		assert.Error(t, err, query)
+	}
+}
@@ -1,14 +1,84 @@
 package main
 
-import "github.com/gorilla/mux"
+import (
+	"log/slog"
+	"net/http"
 
-func NewRouter() *mux.Router {
+	"github.com/gorilla/mux"
+)
+
+// NewRouter returns the router for the HTTP API, serving people from store.
+// Every request is given a request ID and logged with the default slog logger,
+// and requests and store operations are measured and exposed at /metrics.
+// The /person routes require authentication with auth and are authorized
+// with its policy, unless it is nil.
+func NewRouter(store PersonStore, cfg *Config, auth *Authenticator) *mux.Router {
+	metrics := NewMetrics()
+	middleware := []mux.MiddlewareFunc{RequestIDMiddleware(slog.Default()), AccessLogMiddleware, metrics.Middleware}
+
+	// The store of the /person routes is instrumented outside of the
+	// authorization, so that the operations it forbids are measured too. The
+	// health routes are not authenticated, so their store is not authorized.
+	personStore := store
+	if auth != nil {
+		personStore = AuthorizeStore(store, auth.Policy)
+	}
+
+	h := NewPersonHandler(InstrumentStore(personStore, metrics), cfg)
 	router := mux.NewRouter()
-	router.HandleFunc("/person", GetPeople).Methods("GET")
-	router.HandleFunc("/person/{id}", GetPerson).Methods("GET")
-	router.HandleFunc("/person", CreatePerson).Methods("POST")
-	router.HandleFunc("/person/{id}", PatchPerson).Methods("PATCH")
-	router.HandleFunc("/person/{id}", UpdatePerson).Methods("PUT")
-	router.HandleFunc("/person/{id}", DeletePerson).Methods("DELETE")
+	router.Use(middleware...)
+	router.NotFoundHandler = applyMiddleware(http.HandlerFunc(notFound), middleware)
+	router.MethodNotAllowedHandler = applyMiddleware(http.HandlerFunc(methodNotAllowed), middleware)
+
+	// mux paths start with a slash, so /person:batch has a subrouter of its
+	// own, added first as the /person prefix matches it too. It answers 405
+	// itself, as the /person subrouter would answer 404 otherwise.
+	batch := router.Path("/person:batch").Subrouter()
+	batch.MethodNotAllowedHandler = router.MethodNotAllowedHandler
+	people := router.PathPrefix("/person").Subrouter()
+	if auth != nil {
+		batch.Use(auth.Middleware)
+		people.Use(auth.Middleware)
+	}
+
+	batch.HandleFunc("", h.BatchPeople).Methods("POST")
+
+	people.HandleFunc("", h.GetPeople).Methods("GET")
+	people.HandleFunc("", h.CreatePerson).Methods("POST")
+	people.HandleFunc("/export", h.ExportPeople).Methods("GET")
+	people.HandleFunc("/import", h.ImportPeople).Methods("POST")
+	people.HandleFunc("/{id}/history", h.GetPersonHistory).Methods("GET")
+	people.HandleFunc("/{id}/restore", h.RestorePerson).Methods("POST")
+
+	// mux forgets a method mismatch when a later route of the subrouter fails
+	// to match, so the /{id} routes come last to answer 405 for their path.
+	people.HandleFunc("/{id}", h.GetPerson).Methods("GET")
+	people.HandleFunc("/{id}", h.PatchPerson).Methods("PATCH")
+	people.HandleFunc("/{id}", h.UpdatePerson).Methods("PUT")
+	people.HandleFunc("/{id}", h.DeletePerson).Methods("DELETE")
+
+	health := NewHealthHandler(InstrumentStore(store, metrics), cfg)
+	router.HandleFunc("/healthz", health.Live).Methods("GET")
+	router.HandleFunc("/readyz", health.Ready).Methods("GET")
+	router.HandleFunc("/status", health.Status).Methods("GET")
+	router.Handle("/metrics", metrics).Methods("GET")
 	return router
 }
+
+// applyMiddleware wraps the handler in the middleware, the first outermost,
+// as the router does for the handlers of its routes.
+func applyMiddleware(handler http.Handler, middleware []mux.MiddlewareFunc) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+func notFound(w http.ResponseWriter, req *http.Request) {
+	writeProblem(w, req, http.StatusNotFound, "No route matches the request.")
+}
+
+func methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	writeProblem(w, req, http.StatusMethodNotAllowed, "The route does not support the "+req.Method+" method.")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A PersonStore is the storage backend used by the person handlers.
+// MongoStore and MemoryStore are the available implementations.
+//
+// Every change to a person increments their Version, which clients use for
+// optimistic concurrency control.
+//
+// Every method takes a context, and stops and returns the error of the context
+// when it is cancelled or its deadline passes.
+type PersonStore interface {
+	// Close releases the resources of the store, flushing any pending writes.
+	// The store must not be used after it is closed.
+	Close(ctx context.Context) error
+
+	// Mode returns the storage mode of the store, StorageMongo or StorageMemory.
+	Mode() string
+
+	// Ping checks that the store can serve requests.
+	Ping(ctx context.Context) error
+
+	// CreatePersonRecord creates a new person record.
+	CreatePersonRecord(ctx context.Context, person Person) (*Person, error)
+
+	// DeletePersonRecord marks a person record deleted by its ObjectID. The
+	// record is kept until it is purged, and can be restored until then. If
+	// versions are given the record is only deleted if it is at one of them,
+	// and an error wrapping ErrPreconditionFailed is returned otherwise.
+	DeletePersonRecord(ctx context.Context, id string, versions ...int64) (*mongo.DeleteResult, error)
+
+	// RestorePersonRecord undeletes a deleted person record. It returns an
+	// error wrapping ErrConflict if the record is not deleted.
+	RestorePersonRecord(ctx context.Context, id string) (*Person, error)
+
+	// PurgeDeletedPeople permanently removes the person records deleted before
+	// the given time, and returns how many it removed.
+	PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	// CountPeople counts the person records that match the provided query.
+	// Deleted records are counted unless the query excludes them, see notDeleted.
+	CountPeople(ctx context.Context, query bson.M) (int64, error)
+
+	// GetAllPeople retrieves the person records that match the provided query,
+	// paged, sorted and projected according to opts. A nil opts returns every
+	// matching record with all of its fields. Deleted records are returned
+	// unless the query excludes them, see notDeleted.
+	GetAllPeople(ctx context.Context, query bson.M, opts *FindOptions) ([]*Person, error)
+
+	// StreamPeople calls fn with each of the person records GetAllPeople would
+	// return, in order, as they are read, without collecting them first. It
+	// stops and returns the error of fn if fn fails.
+	StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error
+
+	// GetPersonByObjectId retrieves a person record by its ObjectID. Deleted
+	// records are not found.
+	GetPersonByObjectId(ctx context.Context, id string) (*Person, error)
+
+	// PatchPersonRecord atomically applies a patch to an existing person
+	// record. Deleted records are not found. Wrap the patch with IfVersion to
+	// only apply it to a given version of the record.
+	PatchPersonRecord(ctx context.Context, patch PersonPatch, id string) (*Person, error)
+
+	// UpdatePersonRecord replaces an existing person record. Deleted records
+	// are not found. If versions are given the record is only replaced if it
+	// is at one of them, and an error wrapping ErrPreconditionFailed is
+	// returned otherwise. The version of person is ignored.
+	UpdatePersonRecord(ctx context.Context, person Person, id string, versions ...int64) (*Person, error)
+
+	// GetPersonHistory retrieves the audit events of the changes to a person
+	// record, oldest first. Every create, update, patch, delete, restore and
+	// purge records one. The history outlives the record, so it can still be
+	// read once the record is purged.
+	GetPersonHistory(ctx context.Context, id string) ([]AuditEvent, error)
+
+	// ApplyBatch applies the operations in order, which must be valid, and
+	// returns the result of each. An operation that fails does not stop the
+	// others, unless atomic is set: then either every operation is applied or
+	// none is, and the results of all but the failed one are ErrBatchAborted.
+	// The error is only set when the batch as a whole could not be applied.
+	ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error)
+}
+
+// notDeleted returns the query restricted to the person records that are not deleted.
+func notDeleted(query bson.M) bson.M {
+	active := bson.M{"deleted_at": bson.M{"$exists": false}}
+	if len(query) == 0 {
+		return active
+	}
+
+	return bson.M{"$and": []bson.M{query, active}}
+}
+
+// A PersonPatch is a partial modification of a Person, either a JSONPatch or a MergePatch.
+type PersonPatch interface {
+	// Apply returns a patched copy of the person, leaving the original unchanged.
+	Apply(person Person) (*Person, error)
+}
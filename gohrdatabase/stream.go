@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ndjsonMediaType is the media type of a stream of newline delimited JSON
+// values - https://github.com/ndjson/ndjson-spec
+const ndjsonMediaType = "application/x-ndjson"
+
+// A stream is flushed to the client every streamFlushCount people, or sooner
+// if streamFlushInterval passed since it was last flushed.
+const (
+	streamFlushCount    = 100
+	streamFlushInterval = time.Second
+)
+
+// The trailers sent at the end of a stream, as its status is sent before it is
+// known whether the stream can be read to its end. X-Stream-Status is the
+// status code of the stream as a whole, and X-Stream-Error the reason it
+// failed, if it did.
+const (
+	streamStatusTrailer = "X-Stream-Status"
+	streamErrorTrailer  = "X-Stream-Error"
+)
+
+// A peopleEncoder writes people to a stream in the format of its response.
+type peopleEncoder interface {
+	// setHeaders sets the headers of the response, such as its Content-Type.
+	setHeaders(header http.Header)
+	// begin writes what comes before the first person.
+	begin() error
+	encode(person *Person) error
+	// flush writes the people the encoder buffered to the response.
+	flush() error
+}
+
+// A peopleStream writes people to the response with its encoder, flushing
+// them to the client as it goes.
+type peopleStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	encoder peopleEncoder
+	// writeTimeout is how long each flush may take to reach the client.
+	writeTimeout time.Duration
+	started      bool
+	pending      int
+	lastFlush    time.Time
+}
+
+// newPeopleStream returns a peopleStream writing to w with the encoder. Its
+// write deadline is restarted now and on every flush, so that a stream may
+// take longer than the write timeout of the server as long as it keeps
+// reaching the client.
+func newPeopleStream(w http.ResponseWriter, encoder peopleEncoder, writeTimeout time.Duration) *peopleStream {
+	s := &peopleStream{
+		w:            w,
+		rc:           http.NewResponseController(w),
+		encoder:      encoder,
+		writeTimeout: writeTimeout,
+	}
+
+	s.extendDeadline()
+	return s
+}
+
+// newNDJSONStream returns a peopleStream writing people to w as newline
+// delimited JSON, one line each.
+func newNDJSONStream(w http.ResponseWriter, writeTimeout time.Duration) *peopleStream {
+	return newPeopleStream(w, ndjsonEncoder{json.NewEncoder(w)}, writeTimeout)
+}
+
+// An ndjsonEncoder encodes each person as a line of JSON.
+type ndjsonEncoder struct {
+	*json.Encoder
+}
+
+func (e ndjsonEncoder) setHeaders(header http.Header) {
+	header.Set("Content-Type", ndjsonMediaType)
+}
+
+func (e ndjsonEncoder) begin() error {
+	return nil
+}
+
+func (e ndjsonEncoder) encode(person *Person) error {
+	return e.Encode(person)
+}
+
+func (e ndjsonEncoder) flush() error {
+	return nil
+}
+
+// start sends the status and headers of the response, unless they were sent.
+func (s *peopleStream) start() error {
+	if s.started {
+		return nil
+	}
+
+	s.encoder.setHeaders(s.w.Header())
+	s.w.Header().Set("Trailer", streamStatusTrailer+", "+streamErrorTrailer)
+	s.w.WriteHeader(http.StatusOK)
+	s.started = true
+	s.lastFlush = time.Now()
+	return s.encoder.begin()
+}
+
+// write writes the person to the stream. The status and headers of the
+// response are sent with the first person, so that an error before it can
+// still be answered with a problem.
+func (s *peopleStream) write(person *Person) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	if err := s.encoder.encode(person); err != nil {
+		return err
+	}
+
+	s.pending++
+	if s.pending >= streamFlushCount || time.Since(s.lastFlush) >= streamFlushInterval {
+		return s.flush()
+	}
+
+	return nil
+}
+
+// flush sends the pending people to the client.
+func (s *peopleStream) flush() error {
+	if err := s.encoder.flush(); err != nil {
+		return err
+	}
+
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	s.pending = 0
+	s.lastFlush = time.Now()
+	s.extendDeadline()
+	return nil
+}
+
+// extendDeadline restarts the write deadline of the response, if the server
+// supports it.
+func (s *peopleStream) extendDeadline() {
+	_ = s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+}
+
+// finish ends a stream with its trailers, first starting it if no person was
+// written: a 200 status if err is nil, and otherwise the status and detail of
+// the problem for err, which is logged.
+func (s *peopleStream) finish(req *http.Request, err error) {
+	if startErr := s.start(); err == nil {
+		err = startErr
+	}
+
+	// The people already written are sent even if the stream failed.
+	if flushErr := s.flush(); err == nil {
+		err = flushErr
+	}
+
+	status, detail := http.StatusOK, ""
+	if err != nil {
+		problem := errorProblem(req, err)
+		status, detail = problem.Status, strings.Join(strings.Fields(problem.Detail), " ")
+		Logger(req.Context()).Warn("the stream of people failed", "status", status, "error", err.Error())
+	}
+
+	s.w.Header().Set(streamStatusTrailer, strconv.Itoa(status))
+	if detail != "" {
+		s.w.Header().Set(streamErrorTrailer, detail)
+	}
+}
+
+// acceptsMediaType reports whether the Accept header of the request names the
+// media type, without a quality of zero. Wildcards do not count, so that
+// clients accepting anything keep getting the default representation.
+func acceptsMediaType(req *http.Request, mediaType string) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, value := range strings.Split(accept, ",") {
+			accepted, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+			if err != nil || accepted != mediaType {
+				continue
+			}
+
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestAcceptsMediaType tests that only an explicit Accept of a media type selects it.
+func TestAcceptsMediaType(t *testing.T) {
+	tests := []struct {
+		Accept  string
+		Accepts bool
+	}{
+		{"application/x-ndjson", true},
+		{"application/json, application/x-ndjson;q=0.9", true},
+		{"Application/X-NDJSON", true},
+		{"application/x-ndjson;q=0", false},
+		{"application/json", false},
+		{"*/*", false},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/person", nil)
+			req.Header.Set("Accept", tc.Accept)
+			assert.Equal(t, tc.Accepts, acceptsMediaType(req, ndjsonMediaType))
+		})
+	}
+}
+
+// streamRequest gets the target from the router as newline delimited JSON.
+func streamRequest(store PersonStore, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", ndjsonMediaType)
+	NewRouter(store, DefaultConfig(), nil).ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeLines decodes each line of the body as a person.
+func decodeLines(t *testing.T, rec *httptest.ResponseRecorder) []Person {
+	var people []Person
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var person Person
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &person))
+		people = append(people, person)
+	}
+
+	return people
+}
+
+// TestStreamPeople tests that GET /person streams every matching person as a
+// line of newline delimited JSON.
+func TestStreamPeople(t *testing.T) {
+	store := NewMemoryStore()
+	people := make(People, 250)
+	for i := range people {
+		people[i] = Person{Firstname: fmt.Sprintf("Person%03d", i), Lastname: "Smith"}
+	}
+
+	people[42].Lastname = "Jones"
+	store.seed(people)
+
+	rec := streamRequest(store, "/person?lastname=Smith&sort=-firstname")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ndjsonMediaType, rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("X-Total-Count"))
+	assert.Equal(t, "200", rec.Result().Trailer.Get(streamStatusTrailer))
+	assert.Empty(t, rec.Result().Trailer.Get(streamErrorTrailer))
+
+	streamed := decodeLines(t, rec)
+	if assert.Len(t, streamed, 249) {
+		assert.Equal(t, "Person249", streamed[0].Firstname)
+		assert.Equal(t, "Person000", streamed[248].Firstname)
+	}
+
+	rec = streamRequest(store, "/person?limit=10&offset=5&sort=firstname&fields=firstname")
+	streamed = decodeLines(t, rec)
+	if assert.Len(t, streamed, 10) {
+		assert.Equal(t, "Person005", streamed[0].Firstname)
+		assert.Empty(t, streamed[0].Lastname)
+	}
+
+	rec = streamRequest(store, "/person?lastname=Doe")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "200", rec.Result().Trailer.Get(streamStatusTrailer))
+
+	assert.Equal(t, http.StatusBadRequest, streamRequest(store, "/person?limit=0").Code)
+}
+
+// failingStreamStore is a PersonStore whose stream fails after yielding count people.
+type failingStreamStore struct {
+	*fakeStore
+	count int
+}
+
+func (s *failingStreamStore) StreamPeople(ctx context.Context, query bson.M, opts *FindOptions, fn func(person *Person) error) error {
+	for i := 0; i < s.count; i++ {
+		if err := fn(&Person{Firstname: "John", Lastname: "Smith"}); err != nil {
+			return err
+		}
+	}
+
+	return errors.New("cursor failed:\nconnection reset")
+}
+
+// TestStreamPeopleError tests that a stream failing midway is ended with error
+// trailers, and that one failing before the first person is a problem.
+func TestStreamPeopleError(t *testing.T) {
+	rec := streamRequest(&failingStreamStore{fakeStore: newFakeStore(), count: 3}, "/person")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, decodeLines(t, rec), 3)
+	assert.Equal(t, "500", rec.Result().Trailer.Get(streamStatusTrailer))
+	assert.Equal(t, "cursor failed: connection reset", rec.Result().Trailer.Get(streamErrorTrailer))
+
+	rec = streamRequest(&failingStreamStore{fakeStore: newFakeStore()}, "/person")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Result().Trailer)
+}
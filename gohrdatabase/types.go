@@ -1,28 +1,67 @@
 package main
 
 import (
+	"encoding/json"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// A Location represents a Person's location.
+// A Location represents a Person's location. Country is an ISO 3166-1 alpha-2 code.
 type Location struct {
-	City    string `bson:"city,omitempty" json:"city,omitempty"`
-	Country string `bson:"country,omitempty" json:"country,omitempty"`
+	City    string `bson:"city,omitempty" json:"city,omitempty" validate:"max=85"`
+	Country string `bson:"country,omitempty" json:"country,omitempty" validate:"country"`
+}
+
+// The employment statuses of a Person.
+const (
+	EmploymentActive     = "active"
+	EmploymentOnLeave    = "on_leave"
+	EmploymentTerminated = "terminated"
+)
+
+// An Employment represents a Person's job. ManagerID is the id of the person
+// they report to, and StartDate and EndDate are dates in the YYYY-MM-DD
+// format, so that they sort and compare as strings.
+type Employment struct {
+	JobTitle   string `bson:"job_title,omitempty" json:"job_title,omitempty" validate:"max=100"`
+	Department string `bson:"department,omitempty" json:"department,omitempty" validate:"max=100"`
+	ManagerID  string `bson:"manager_id,omitempty" json:"manager_id,omitempty" validate:"objectid"`
+	StartDate  string `bson:"start_date,omitempty" json:"start_date,omitempty" validate:"date"`
+	EndDate    string `bson:"end_date,omitempty" json:"end_date,omitempty" validate:"date"`
+	Status     string `bson:"status,omitempty" json:"status,omitempty" validate:"oneof=active on_leave terminated"`
+	SalaryBand string `bson:"salary_band,omitempty" json:"salary_band,omitempty" validate:"max=10"`
 }
 
-// A Patch represents a Json Patch structure - http://jsonpatch.com/
+// A Patch represents a single Json Patch operation - https://datatracker.ietf.org/doc/html/rfc6902
+// Path and From are JSON pointers into the Person's JSON representation, e.g. "/location/city".
 type Patch struct {
-	Op    string `json:"op"`
-	Path  string `json:"path"`
-	Value string `json:"value"`
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
 }
 
-// A Person represents a user.
+// A Person represents a user. The validate tags are checked by ValidatePerson.
 type Person struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Firstname string             `bson:"firstname,omitempty" json:"firstname,omitempty"`
-	Lastname  string             `bson:"lastname,omitempty" json:"lastname,omitempty"`
+	Firstname string             `bson:"firstname,omitempty" json:"firstname,omitempty" validate:"required,max=50"`
+	Lastname  string             `bson:"lastname,omitempty" json:"lastname,omitempty" validate:"required,max=50"`
 	Location  *Location          `json:"location,omitempty"`
+	// EmployeeNumber is the number the organisation knows the person by.
+	EmployeeNumber string `bson:"employee_number,omitempty" json:"employee_number,omitempty" validate:"max=20"`
+	// Email is the work email address of the person, without a display name.
+	Email string `bson:"email,omitempty" json:"email,omitempty" validate:"max=254,email"`
+	// Phone is the work phone number of the person in the E.164 format, e.g. "+442071234567".
+	Phone      string      `bson:"phone,omitempty" json:"phone,omitempty" validate:"phone"`
+	Employment *Employment `bson:"employment,omitempty" json:"employment,omitempty"`
+	// DeletedAt is when the person was deleted, or nil if they are not. It is
+	// set by the store, and any value in a request is ignored.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// Version is 1 when the person is created, and is incremented by the store
+	// on every change to them. It is the ETag of the person. Any value in a
+	// request is ignored.
+	Version int64 `bson:"version" json:"version,omitempty"`
 }
 
 type QueryFilter struct {
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dateLayout is the layout of the dates of a Person, e.g. "2024-03-01".
+const dateLayout = "2006-01-02"
+
+// phonePattern matches an E.164 phone number: a plus sign, then up to 15
+// digits starting with the country code.
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// A ValidationError lists the fields of a Person that failed validation.
+type ValidationError struct {
+	Violations []Violation
+}
+
+// A Violation describes a field that failed validation. Name is the dotted JSON
+// path of the field, e.g. "location.country".
+type Violation struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		reasons = append(reasons, violation.Name+": "+violation.Reason)
+	}
+
+	return "invalid person: " + strings.Join(reasons, "; ")
+}
+
+// Is reports that a ValidationError matches ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ValidatePerson checks the person against the rules in the validate struct tags
+// of Person, Location and Employment, returning a *ValidationError listing
+// every violation. An employment must also not end before it starts.
+//
+// The supported rules are:
+//
+//	required  the field must not be empty
+//	min=N     a string must be at least N characters long
+//	max=N     a string must be at most N characters long
+//	country   a string must be empty or an ISO 3166-1 alpha-2 country code
+//	email     a string must be empty or an email address without a display name
+//	phone     a string must be empty or an E.164 phone number, e.g. +442071234567
+//	date      a string must be empty or a date in the YYYY-MM-DD format
+//	objectid  a string must be empty or a 24 character hexadecimal ObjectID
+//	oneof=A B a string must be empty or one of the space separated values
+//
+// Nested structs and pointers to structs are validated when they are set.
+func ValidatePerson(person Person) error {
+	violations := validateStruct(reflect.ValueOf(person), "")
+	if person.Employment != nil && person.Employment.endsBeforeStart() &&
+		!hasViolation(violations, "employment.start_date", "employment.end_date") {
+		violations = append(violations, Violation{Name: "employment.end_date", Reason: "must not be before the start date"})
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// endsBeforeStart reports whether the employment has both dates, and ends before it starts.
+func (e *Employment) endsBeforeStart() bool {
+	return e.StartDate != "" && e.EndDate != "" && e.EndDate < e.StartDate
+}
+
+// hasViolation reports whether any of the fields has a violation.
+func hasViolation(violations []Violation, names ...string) bool {
+	for _, violation := range violations {
+		for _, name := range names {
+			if violation.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func validateStruct(v reflect.Value, prefix string) []Violation {
+	var violations []Violation
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonFieldName(field)
+		value := v.Field(i)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "" {
+				continue
+			}
+
+			if reason := checkRule(rule, value); reason != "" {
+				violations = append(violations, Violation{Name: name, Reason: reason})
+				break
+			}
+		}
+
+		if value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+
+		if value.Kind() == reflect.Struct {
+			violations = append(violations, validateStruct(value, name+".")...)
+		}
+	}
+
+	return violations
+}
+
+// checkRule returns the reason the value breaks the rule, or "" if it does not.
+func checkRule(rule string, value reflect.Value) string {
+	name, param, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if value.IsZero() || (value.Kind() == reflect.String && strings.TrimSpace(value.String()) == "") {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.Atoi(param)
+		if err != nil || value.Kind() != reflect.String {
+			panic(fmt.Sprintf("invalid validate rule %q", rule))
+		}
+
+		length := utf8.RuneCountInString(value.String())
+		if name == "min" && length < limit {
+			return fmt.Sprintf("must be at least %d characters", limit)
+		}
+
+		if name == "max" && length > limit {
+			return fmt.Sprintf("must be at most %d characters", limit)
+		}
+	case "country":
+		if code := value.String(); code != "" && !countryCodes[code] {
+			return fmt.Sprintf("%q is not an ISO 3166-1 alpha-2 country code", code)
+		}
+	case "email":
+		if address := value.String(); address != "" {
+			if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+				return fmt.Sprintf("%q is not an email address", address)
+			}
+		}
+	case "phone":
+		if number := value.String(); number != "" && !phonePattern.MatchString(number) {
+			return fmt.Sprintf("%q is not an E.164 phone number", number)
+		}
+	case "date":
+		if date := value.String(); date != "" {
+			if _, err := time.Parse(dateLayout, date); err != nil {
+				return fmt.Sprintf("%q is not a date in the YYYY-MM-DD format", date)
+			}
+		}
+	case "objectid":
+		if id := value.String(); id != "" && !primitive.IsValidObjectID(id) {
+			return fmt.Sprintf("%q is not an ObjectID", id)
+		}
+	case "oneof":
+		if choice := value.String(); choice != "" && !slices.Contains(strings.Fields(param), choice) {
+			return fmt.Sprintf("must be one of %s", strings.Join(strings.Fields(param), ", "))
+		}
+	default:
+		panic(fmt.Sprintf("unknown validate rule %q", rule))
+	}
+
+	return ""
+}
+
+// jsonFieldName returns the name of the field in the JSON representation.
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidatePerson tests the function ValidatePerson
+func TestValidatePerson(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Person     Person
+		Violations []Violation
+	}{
+		{
+			"valid",
+			Person{Firstname: "John", Lastname: "Smith", Location: &Location{City: "London", Country: "GB"}},
+			nil,
+		},
+		{
+			"valid_without_location",
+			Person{Firstname: "Émilie", Lastname: "Dupont"},
+			nil,
+		},
+		{
+			"required",
+			Person{Firstname: " ", Location: &Location{}},
+			[]Violation{
+				{Name: "firstname", Reason: "is required"},
+				{Name: "lastname", Reason: "is required"},
+			},
+		},
+		{
+			"max_length",
+			Person{Firstname: strings.Repeat("a", 51), Lastname: "Smith", Location: &Location{City: strings.Repeat("b", 86)}},
+			[]Violation{
+				{Name: "firstname", Reason: "must be at most 50 characters"},
+				{Name: "location.city", Reason: "must be at most 85 characters"},
+			},
+		},
+		{
+			"country_code",
+			Person{Firstname: "John", Lastname: "Smith", Location: &Location{Country: "UK"}},
+			[]Violation{
+				{Name: "location.country", Reason: `"UK" is not an ISO 3166-1 alpha-2 country code`},
+			},
+		},
+		{
+			"employment",
+			Person{
+				Firstname:      "John",
+				Lastname:       "Smith",
+				EmployeeNumber: "E00042",
+				Email:          "john.smith@example.com",
+				Phone:          "+442071234567",
+				Employment: &Employment{
+					JobTitle:   "Manager",
+					Department: "Sales",
+					ManagerID:  "650000000000000000000000",
+					StartDate:  "2020-01-06",
+					EndDate:    "2024-02-29",
+					Status:     EmploymentTerminated,
+					SalaryBand: "B6",
+				},
+			},
+			nil,
+		},
+		{
+			"invalid_employment",
+			Person{
+				Firstname: "John",
+				Lastname:  "Smith",
+				Email:     "John Smith <john.smith@example.com>",
+				Phone:     "020 7123 4567",
+				Employment: &Employment{
+					ManagerID: "1",
+					StartDate: "2023-02-29",
+					EndDate:   "06/01/2020",
+					Status:    "retired",
+				},
+			},
+			[]Violation{
+				{Name: "email", Reason: `"John Smith <john.smith@example.com>" is not an email address`},
+				{Name: "phone", Reason: `"020 7123 4567" is not an E.164 phone number`},
+				{Name: "employment.manager_id", Reason: `"1" is not an ObjectID`},
+				{Name: "employment.start_date", Reason: `"2023-02-29" is not a date in the YYYY-MM-DD format`},
+				{Name: "employment.end_date", Reason: `"06/01/2020" is not a date in the YYYY-MM-DD format`},
+				{Name: "employment.status", Reason: "must be one of active, on_leave, terminated"},
+			},
+		},
+		{
+			"ends_before_start",
+			Person{Firstname: "John", Lastname: "Smith", Employment: &Employment{StartDate: "2020-01-06", EndDate: "2019-12-31"}},
+			[]Violation{
+				{Name: "employment.end_date", Reason: "must not be before the start date"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidatePerson(tc.Person)
+			if tc.Violations == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Equal(t, &ValidationError{Violations: tc.Violations}, err)
+		})
+	}
+}
+
+// TestGeneratePeople tests that the seed data is valid, and that every manager
+// is one of the people.
+func TestGeneratePeople(t *testing.T) {
+	people := generatePeople(50)
+	ids := make(map[string]bool)
+	for _, person := range people {
+		ids[person.ID.Hex()] = true
+	}
+
+	for _, person := range people {
+		assert.NoError(t, ValidatePerson(person))
+		if person.Employment.ManagerID != "" {
+			assert.True(t, ids[person.Employment.ManagerID], person.Employment.ManagerID)
+		}
+	}
+
+	assert.Empty(t, people[0].Employment.ManagerID)
+	assert.NotEmpty(t, people[1].Employment.ManagerID)
+}
+
+// TestMigrateCountry tests that the legacy country names are replaced with
+// valid codes, and that other countries are left alone.
+func TestMigrateCountry(t *testing.T) {
+	for name, code := range legacyCountryNames {
+		person := Person{Location: &Location{Country: name}}
+		assert.True(t, migrateCountry(&person), name)
+		assert.True(t, countryCodes[code], code)
+		assert.Equal(t, code, person.Location.Country)
+	}
+
+	person := Person{Location: &Location{Country: "GB"}}
+	assert.False(t, migrateCountry(&person))
+	assert.Equal(t, "GB", person.Location.Country)
+	assert.False(t, migrateCountry(&Person{}))
+}
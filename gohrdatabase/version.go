@@ -0,0 +1,32 @@
+package main
+
+import "runtime/debug"
+
+// version is the release of the server, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// buildVersion returns the version of the server, falling back to the module
+// version or VCS revision recorded by the Go toolchain when version is not set.
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return version + "+" + setting.Value
+		}
+	}
+
+	return version
+}